@@ -3,10 +3,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,23 +29,33 @@ import (
 )
 
 var (
-	refreshInterval = flag.Int64("refresh-interval", 120, "Interval between scrapes to OpenStack API (default 120s)")
-	addr            = flag.String("addr", ":9183", "Address to listen on")
-	cloudConfFile   = flag.String("cloud-conf", "", "path to the cloud.conf file. If this path is not set the scraper will use the usual OpenStack environment variables.")
-	kubeconfig      = flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "Path to the kubeconfig file to use for CLI requests. (uses in-cluster config if empty)")
-	metricsPrefix   = flag.String("metrics-prefix", metrics.DefaultMetricsPrefix, "Prefix used for all metrics")
+	refreshInterval  = flag.Int64("refresh-interval", 120, "Interval between scrapes to OpenStack API (default 120s)")
+	addr             = flag.String("addr", ":9183", "Address to listen on")
+	cloudConfFile    = flag.String("cloud-conf", "", "path to the cloud.conf file. If this path is not set the scraper will use the usual OpenStack environment variables.")
+	kubeconfig       = flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "Paths to the kubeconfig files to use for CLI requests, separated like in KUBECONFIG. (uses in-cluster config if empty)")
+	kubeContexts     = flag.String("kube-contexts", "", "Comma separated contexts of the kubeconfig files, one per cluster (uses the current context if empty)")
+	clusterName      = flag.String("cluster-name", "", "Name of the cluster kosmoo runs in, used as k8s_cluster label with the in-cluster config")
+	ownerClusters    = flag.String("owner-clusters", "", "Comma separated names of the clusters whose OpenStack resources are exported (exports all resources if empty)")
+	serverClusterKey = flag.String("server-cluster-key", "", "Server metadata key or tag prefix (<key>=<cluster>) with the name of the cluster owning the server")
+	nodeNamePattern  = flag.String("node-name-pattern", "", "Regular expression matching the server names of the cluster nodes, the group named cluster contains the name of the owning cluster")
+	kubernetesMode   = flag.String("kubernetes", "auto", "Correlate the OpenStack resources with Kubernetes: true, false or auto (enabled if a kubeconfig is set or kosmoo runs in a cluster)")
+	metricsPrefix    = flag.String("metrics-prefix", metrics.DefaultMetricsPrefix, "Prefix used for all metrics")
+	cinderCSIDrivers = flag.String("cinder-csi-drivers", metrics.DefaultCinderCSIDriver, "Comma separated names of the csi drivers whose pvs are cinder volumes")
+	manilaCSIDrivers = flag.String("manila-csi-drivers", strings.Join(metrics.DefaultManilaCSIDrivers, ","), "Comma separated names of the csi drivers whose pvs are manila shares")
 )
 
 var (
-	scrapeDuration *prometheus.GaugeVec
-	scrapedAt      *prometheus.GaugeVec
-	scrapedStatus  *prometheus.GaugeVec
+	scrapeDuration        *prometheus.GaugeVec
+	scrapedAt             *prometheus.GaugeVec
+	scrapedStatus         *prometheus.GaugeVec
+	kubernetesIntegration prometheus.Gauge
 )
 
 var (
-	clientset       *kubernetes.Clientset
 	backoffSleep    = time.Second
 	maxBackoffSleep = time.Hour
+	// time to wait at startup for the kubernetes informers to sync
+	informerSyncTimeout = time.Minute
 )
 
 func registerMetrics(prefix string) {
@@ -65,10 +80,17 @@ func registerMetrics(prefix string) {
 		},
 		[]string{"refresh_interval"},
 	)
+	kubernetesIntegration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metrics.AddPrefix("kubernetes_integration_enabled", prefix),
+			Help: "Kubernetes integration enabled (1) or disabled (0)",
+		},
+	)
 
 	prometheus.MustRegister(scrapeDuration)
 	prometheus.MustRegister(scrapedAt)
 	prometheus.MustRegister(scrapedStatus)
+	prometheus.MustRegister(kubernetesIntegration)
 }
 
 // metrixMutex locks to prevent race-conditions between scraping the metrics
@@ -79,11 +101,49 @@ func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	switch *kubernetesMode {
+	case "true", "false", "auto":
+	default:
+		klog.Fatalf("invalid value %q for -kubernetes: must be true, false or auto", *kubernetesMode)
+	}
+
 	klog.Infof("starting kosmoo at %s", *addr)
 
+	metrics.SetCSIDrivers(splitList(*cinderCSIDrivers), splitList(*manilaCSIDrivers))
+
+	ownership := metrics.ClusterOwnership{
+		ServerKey: *serverClusterKey,
+		Clusters:  splitList(*ownerClusters),
+	}
+	if *nodeNamePattern != "" {
+		pattern, err := regexp.Compile(*nodeNamePattern)
+		if err != nil {
+			klog.Fatalf("invalid value for -node-name-pattern: %v", err)
+		}
+		if pattern.SubexpIndex("cluster") < 0 {
+			klog.Fatalf("invalid value for -node-name-pattern: the group named cluster is missing")
+		}
+		ownership.NodeNamePattern = pattern
+	}
+	metrics.SetClusterOwnership(ownership)
+
 	registerMetrics(*metricsPrefix)
 	metrics.RegisterMetrics(*metricsPrefix)
 
+	// the kubernetes informers are started once and keep running when the
+	// openstack part is restarted, so their stop channel is never closed
+	k8sCaches, err := newKubernetesCaches()
+	if err != nil {
+		klog.Fatalf("unable to create kubernetes caches: %v", err)
+	}
+	if len(k8sCaches) > 0 {
+		kubernetesIntegration.Set(1)
+	}
+	stopCh := make(chan struct{})
+	for _, k8sCache := range k8sCaches {
+		k8sCache.Start(stopCh)
+	}
+
 	// start prometheus metrics endpoint
 	go func() {
 		// klog.Info().Str("addr", *addr).Msg("starting prometheus http endpoint")
@@ -104,12 +164,16 @@ func main() {
 			}
 		})
 
+		metricsMux.HandleFunc("/readyz", readyzHandler(k8sCaches))
+
 		err := http.ListenAndServe(*addr, metricsMux)
 		klog.Fatalf("prometheus http.ListenAndServe failed: %v", err)
 	}()
 
+	waitForKubernetesCaches(k8sCaches)
+
 	for {
-		if run() != nil {
+		if run(k8sCaches) != nil {
 			klog.Errorf("error during run - sleeping %s", backoffSleep)
 			time.Sleep(backoffSleep)
 			backoffSleep = min(2*backoffSleep, maxBackoffSleep)
@@ -118,7 +182,7 @@ func main() {
 }
 
 // run does the initialization of the operational exporter and also the metrics scraping
-func run() error {
+func run(k8sCaches []*metrics.KubernetesCache) error {
 	var authOpts gophercloud.AuthOptions
 	var endpointOpts gophercloud.EndpointOpts
 	var err error
@@ -144,17 +208,6 @@ func run() error {
 		klog.Info("OpenStack credentials read from environment")
 	}
 
-	// get kubernetes clientset
-	var config *rest.Config
-	config, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		return logError("unable to get kubernetes config: %v", err)
-	}
-	clientset, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		return logError("error creating kubernetes Clientset: %v", err)
-	}
-
 	// authenticate to OpenStack
 	provider, err := openstack.AuthenticatedClient(authOpts)
 	if err != nil {
@@ -166,7 +219,7 @@ func run() error {
 
 	// start scraping loop
 	for {
-		err := updateMetrics(provider, endpointOpts, clientset, authOpts.TenantID)
+		err := updateMetrics(provider, endpointOpts, k8sCaches, authOpts.TenantID)
 		if err != nil {
 			return err
 		}
@@ -225,10 +278,12 @@ func authOptsFromCloudConf(path string) (gophercloud.AuthOptions, gophercloud.En
 	return ao, eo, nil
 }
 
-func updateMetrics(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, clientset *kubernetes.Clientset, tenantID string) error {
+func updateMetrics(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, k8sCaches []*metrics.KubernetesCache, tenantID string) error {
 	metricsMutex.Lock()
 	defer metricsMutex.Unlock()
 
+	k8sCaches = syncedKubernetesCaches(k8sCaches)
+
 	var errs []error
 	scrapeStart := time.Now()
 
@@ -237,26 +292,84 @@ func updateMetrics(provider *gophercloud.ProviderClient, eo gophercloud.Endpoint
 		err := logError("creating openstack clients failed: %v", err)
 		errs = append(errs, err)
 	} else {
-		if err := metrics.PublishCinderMetrics(cinderClient, clientset, tenantID); err != nil {
+		// the resources needed by several collectors are listed once per refresh
+		resources := metrics.NewOpenStackResources(cinderClient, computeClient, neutronClient, loadbalancerClient, tenantID)
+
+		if err := metrics.PublishCinderMetrics(cinderClient, resources, k8sCaches, tenantID); err != nil {
 			err := logError("scraping cinder metrics failed: %v", err)
 			errs = append(errs, err)
 		}
 
-		if err := metrics.PublishNeutronMetrics(neutronClient, tenantID); err != nil {
+		if manilaClient, err := getManilaClient(provider, eo); err != nil {
+			err := logError("creating manila client failed: %v", err)
+			errs = append(errs, err)
+		} else if manilaClient != nil {
+			if err := metrics.PublishManilaMetrics(manilaClient, k8sCaches, tenantID); err != nil {
+				err := logError("scraping manila metrics failed: %v", err)
+				errs = append(errs, err)
+			}
+		}
+
+		if err := metrics.PublishVolumeAttachmentMetrics(resources, k8sCaches); err != nil {
+			err := logError("scraping volume attachment metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishNeutronMetrics(neutronClient, resources, k8sCaches, tenantID); err != nil {
 			err := logError("scraping neutron metrics failed: %v", err)
 			errs = append(errs, err)
 		}
 
-		if err := metrics.PublishLoadBalancerMetrics(loadbalancerClient, tenantID); err != nil {
+		if err := metrics.PublishNeutronQuotaMetrics(neutronClient, tenantID); err != nil {
+			err := logError("scraping neutron quota metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishPortMetrics(resources); err != nil {
+			err := logError("scraping port metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishNetworkMetrics(neutronClient, tenantID); err != nil {
+			err := logError("scraping network metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishRouterMetrics(neutronClient, resources, tenantID); err != nil {
+			err := logError("scraping router metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishSecurityGroupMetrics(neutronClient, resources, tenantID); err != nil {
+			err := logError("scraping security group metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishLoadBalancerMetrics(loadbalancerClient, resources, k8sCaches, tenantID); err != nil {
 			err := logError("scraping load balancer metrics failed: %v", err)
 			errs = append(errs, err)
 		}
 
-		if err := metrics.PublishServerMetrics(computeClient, tenantID); err != nil {
+		if err := metrics.PublishAmphoraMetrics(loadbalancerClient, resources, tenantID); err != nil {
+			err := logError("scraping amphora metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishOctaviaMetrics(loadbalancerClient, resources, tenantID); err != nil {
+			err := logError("scraping octavia metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
+		if err := metrics.PublishServerMetrics(computeClient, resources, k8sCaches, tenantID); err != nil {
 			err := logError("scraping server metrics failed: %v", err)
 			errs = append(errs, err)
 		}
 
+		if err := metrics.PublishServerGroupMetrics(computeClient, resources); err != nil {
+			err := logError("scraping server group metrics failed: %v", err)
+			errs = append(errs, err)
+		}
+
 		if err := metrics.PublishFirewallV1Metrics(neutronClient, tenantID); err != nil {
 			err := logError("scraping firewall v1 metrics failed: %v", err)
 			errs = append(errs, err)
@@ -322,6 +435,171 @@ func getClients(provider *gophercloud.ProviderClient, endpointOpts gophercloud.E
 	return cinderClient, neutronClient, loadbalancerClient, computeClient, nil
 }
 
+// getManilaClient returns the client of the shared file system api or nil,
+// if manila is not available.
+func getManilaClient(provider *gophercloud.ProviderClient, endpointOpts gophercloud.EndpointOpts) (*gophercloud.ServiceClient, error) {
+	if _, err := provider.EndpointLocator(gophercloud.EndpointOpts{Type: "sharev2", Availability: gophercloud.AvailabilityPublic}); err != nil {
+		return nil, nil
+	}
+
+	manilaClient, err := openstack.NewSharedFileSystemV2(provider, endpointOpts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get manila client: %v", err)
+	}
+	return manilaClient, nil
+}
+
+// splitList splits a comma separated list and drops empty items
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// kubernetesConfigs returns the configs of the kubernetes clusters by their
+// name or none, if the kubernetes integration is disabled. In auto mode it is
+// enabled if a kubeconfig is set or kosmoo runs inside a cluster.
+// The kubeconfig files are merged like kubectl does, every context is a
+// cluster named like the context.
+func kubernetesConfigs() (map[string]*rest.Config, error) {
+	if *kubernetesMode == "false" {
+		return nil, nil
+	}
+
+	if *kubeconfig == "" {
+		config, err := rest.InClusterConfig()
+		if err == rest.ErrNotInCluster && *kubernetesMode == "auto" {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]*rest.Config{*clusterName: config}, nil
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: filepath.SplitList(*kubeconfig)}
+	contexts := splitList(*kubeContexts)
+	if len(contexts) == 0 {
+		raw, err := rules.Load()
+		if err != nil {
+			return nil, err
+		}
+		contexts = []string{raw.CurrentContext}
+	}
+
+	configs := map[string]*rest.Config{}
+	for _, kubeContext := range contexts {
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get config of context %q: %v", kubeContext, err)
+		}
+		configs[kubeContext] = config
+	}
+	return configs, nil
+}
+
+// newKubernetesCaches creates the kubernetes caches of all clusters, there are
+// none if the kubernetes integration is disabled.
+func newKubernetesCaches() ([]*metrics.KubernetesCache, error) {
+	configs, err := kubernetesConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get kubernetes config: %v", err)
+	}
+	if len(configs) == 0 {
+		klog.Info("kubernetes integration is disabled, the kubernetes labels stay empty")
+		return nil, nil
+	}
+
+	var clusters []string
+	for cluster := range configs {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	var caches []*metrics.KubernetesCache
+	for _, cluster := range clusters {
+		clientset, err := kubernetes.NewForConfig(configs[cluster])
+		if err != nil {
+			return nil, fmt.Errorf("error creating kubernetes Clientset of cluster %s: %v", cluster, err)
+		}
+		caches = append(caches, metrics.NewKubernetesCache(cluster, clientset, 0))
+	}
+	return caches, nil
+}
+
+// waitForKubernetesCaches waits up to informerSyncTimeout for the informers of
+// all clusters to sync. The scrape does not wait for clusters which are not
+// reachable, their sync status is reported by /readyz.
+func waitForKubernetesCaches(caches []*metrics.KubernetesCache) {
+	ctx, cancel := context.WithTimeout(context.Background(), informerSyncTimeout)
+	defer cancel()
+	for _, k8sCache := range caches {
+		if k8sCache.WaitForSync(ctx.Done()) {
+			klog.Infof("kubernetes informers of cluster %s have synced", k8sCache.Cluster())
+		} else {
+			klog.Warningf("kubernetes informers of cluster %s did not sync within %s, the cluster is skipped until they have synced", k8sCache.Cluster(), informerSyncTimeout)
+		}
+	}
+}
+
+// syncedKubernetesCaches returns the caches whose informers have synced, the
+// openstack resources are only correlated with the objects of these clusters.
+func syncedKubernetesCaches(caches []*metrics.KubernetesCache) []*metrics.KubernetesCache {
+	var synced []*metrics.KubernetesCache
+	for _, k8sCache := range caches {
+		if !k8sCache.HasSynced() {
+			klog.Warningf("skipping kubernetes cluster %s as its informers have not synced", k8sCache.Cluster())
+			continue
+		}
+		synced = append(synced, k8sCache)
+	}
+	return synced
+}
+
+// readyzHandler reports the sync status of the kubernetes informers, kosmoo is
+// ready once all informers have synced or if the kubernetes integration is
+// disabled.
+func readyzHandler(caches []*metrics.KubernetesCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready := true
+		var body strings.Builder
+		if len(caches) == 0 {
+			body.WriteString("kubernetes integration disabled\n")
+		}
+		for _, c := range caches {
+			status := c.SyncStatus()
+			var resources []string
+			for resource := range status {
+				resources = append(resources, resource)
+			}
+			sort.Strings(resources)
+
+			for _, resource := range resources {
+				synced := "synced"
+				if !status[resource] {
+					synced = "not synced"
+					ready = false
+				}
+				fmt.Fprintf(&body, "informer %s of cluster %q: %s\n", resource, c.Cluster(), synced)
+			}
+		}
+
+		if ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, err := w.Write([]byte(body.String())); err != nil {
+			klog.Warningf("error handling /readyz: %v", err)
+		}
+	}
+}
+
 func min(a, b time.Duration) time.Duration {
 	if a < b {
 		return a
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeKubeconfig writes a kubeconfig with a cluster and a context per name,
+// the server of a cluster is https://<name>.example.com
+func writeKubeconfig(t *testing.T, dir, file, currentContext string, names ...string) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\nkind: Config\n")
+	b.WriteString("current-context: " + currentContext + "\n")
+	b.WriteString("clusters:\n")
+	for _, name := range names {
+		b.WriteString("- name: " + name + "\n  cluster:\n    server: https://" + name + ".example.com\n")
+	}
+	b.WriteString("contexts:\n")
+	for _, name := range names {
+		b.WriteString("- name: " + name + "\n  context:\n    cluster: " + name + "\n    user: user\n")
+	}
+	b.WriteString("users:\n- name: user\n  user:\n    token: secret\n")
+
+	path := filepath.Join(dir, file)
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubernetesConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kosmoo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := writeKubeconfig(t, dir, "first", "prod", "prod", "stage")
+	second := writeKubeconfig(t, dir, "second", "dev", "dev")
+
+	defer func(mode, config, contexts string) {
+		*kubernetesMode, *kubeconfig, *kubeContexts = mode, config, contexts
+	}(*kubernetesMode, *kubeconfig, *kubeContexts)
+
+	tests := []struct {
+		name       string
+		mode       string
+		kubeconfig string
+		contexts   string
+		// want holds the expected clusters
+		want    []string
+		wantErr bool
+	}{
+		{name: "disabled", mode: "false", kubeconfig: first},
+		{name: "auto outside of a cluster", mode: "auto"},
+		{name: "enabled outside of a cluster", mode: "true", wantErr: true},
+		{name: "current context", mode: "auto", kubeconfig: first, want: []string{"prod"}},
+		{name: "contexts", mode: "true", kubeconfig: first, contexts: "prod, stage", want: []string{"prod", "stage"}},
+		{name: "merged files", mode: "auto", kubeconfig: first + string(filepath.ListSeparator) + second, contexts: "stage,dev", want: []string{"dev", "stage"}},
+		{name: "current context of the first file", mode: "auto", kubeconfig: second + string(filepath.ListSeparator) + first, want: []string{"dev"}},
+		{name: "unknown context", mode: "auto", kubeconfig: first, contexts: "prod,unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.kubeconfig == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+				t.Skip("the in-cluster config is available")
+			}
+			*kubernetesMode, *kubeconfig, *kubeContexts = tt.mode, tt.kubeconfig, tt.contexts
+
+			configs, err := kubernetesConfigs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("kubernetesConfigs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var clusters []string
+			for cluster, config := range configs {
+				clusters = append(clusters, cluster)
+				if want := "https://" + cluster + ".example.com"; config.Host != want {
+					t.Errorf("host of cluster %s = %s, want %s", cluster, config.Host, want)
+				}
+			}
+			sort.Strings(clusters)
+			if strings.Join(clusters, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("kubernetesConfigs() clusters = %v, want %v", clusters, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/amphorae"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	loadbalancerAmphoraStatus         *prometheus.GaugeVec
+	loadbalancerAmphoraCertExpiration *prometheus.GaugeVec
+	loadbalancerAmphoraCreatedAt      *prometheus.GaugeVec
+	loadbalancerAmphoraUpdatedAt      *prometheus.GaugeVec
+	loadbalancerAmphoraeAllocated     *prometheus.GaugeVec
+
+	// Status from https://docs.openstack.org/api-ref/load-balancer/v2/index.html#list-amphora
+	amphoraStates = []string{"BOOTING", "ALLOCATED", "READY", "PENDING_CREATE", "PENDING_DELETE", "DELETED", "ERROR"}
+
+	amphoraLabels = []string{"id", "loadbalancer_id", "compute_id", "image_id", "role", "owner_cluster"}
+)
+
+func registerAmphoraMetrics() {
+	loadbalancerAmphoraStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_amphora_status"),
+			Help: "Load balancer amphora status",
+		},
+		append(amphoraLabels, "status"),
+	)
+	loadbalancerAmphoraCertExpiration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_amphora_cert_expiration"),
+			Help: "Load balancer amphora certificate expiration",
+		},
+		amphoraLabels,
+	)
+	loadbalancerAmphoraCreatedAt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_amphora_created_at"),
+			Help: "Load balancer amphora created at",
+		},
+		amphoraLabels,
+	)
+	loadbalancerAmphoraUpdatedAt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_amphora_updated_at"),
+			Help: "Load balancer amphora updated at",
+		},
+		amphoraLabels,
+	)
+	loadbalancerAmphoraeAllocated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_amphorae_allocated"),
+			Help: "Number of allocated amphorae of a load balancer",
+		},
+		[]string{"loadbalancer_id"},
+	)
+
+	prometheus.MustRegister(loadbalancerAmphoraStatus)
+	prometheus.MustRegister(loadbalancerAmphoraCertExpiration)
+	prometheus.MustRegister(loadbalancerAmphoraCreatedAt)
+	prometheus.MustRegister(loadbalancerAmphoraUpdatedAt)
+	prometheus.MustRegister(loadbalancerAmphoraeAllocated)
+}
+
+// PublishAmphoraMetrics makes the list request to the octavia amphora api and
+// passes the result to a publish function. The api is restricted to admins by
+// the default octavia policy and does not exist for neutron lbaas, so a
+// forbidden or not found response only skips the metrics.
+func PublishAmphoraMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, tenantID string) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("loadbalancer_amphora", "list")
+	pages, err := amphorae.List(client, amphorae.ListOpts{}).AllPages()
+	if mc.Observe(err) != nil {
+		switch err.(type) {
+		case gophercloud.ErrDefault403, gophercloud.ErrDefault404:
+			// reset metrics if the api is not usable to not publish them anymore
+			resetAmphoraMetrics()
+			klog.V(4).Infof("skipping amphora metrics as the api is not usable: %v", err)
+			return nil
+		}
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list amphorae: %v", err)
+		return err
+	}
+	amphoraList, err := amphorae.ExtractAmphorae(pages)
+	if err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract amphorae: %v", err)
+		return err
+	}
+
+	// the amphorae belong to the cluster of their load balancer
+	lbs, err := resources.LoadBalancers()
+	if err != nil {
+		return err
+	}
+	devices := deviceClusters(nil, lbs)
+	amphoraList = filterAmphorae(amphoraList, devices)
+
+	// second step: reset the old metrics
+	resetAmphoraMetrics()
+
+	// third step: publish the metrics
+	allocated := map[string]int{}
+	for _, amphora := range amphoraList {
+		publishAmphoraMetric(amphora, devices[amphora.LoadbalancerID])
+		if amphora.LoadbalancerID != "" && amphora.Status == "ALLOCATED" {
+			allocated[amphora.LoadbalancerID]++
+		}
+	}
+	for lbID, count := range allocated {
+		loadbalancerAmphoraeAllocated.WithLabelValues(lbID).Set(float64(count))
+	}
+
+	return nil
+}
+
+// resetAmphoraMetrics resets the amphora metrics
+func resetAmphoraMetrics() {
+	loadbalancerAmphoraStatus.Reset()
+	loadbalancerAmphoraCertExpiration.Reset()
+	loadbalancerAmphoraCreatedAt.Reset()
+	loadbalancerAmphoraUpdatedAt.Reset()
+	loadbalancerAmphoraeAllocated.Reset()
+}
+
+// publishAmphoraMetric extracts data from an amphora and exposes the metrics via prometheus
+func publishAmphoraMetric(amphora amphorae.Amphora, ownerCluster string) {
+	labels := []string{amphora.ID, amphora.LoadbalancerID, amphora.ComputeID, amphora.ImageID, amphora.Role, ownerCluster}
+
+	if !amphora.CertExpiration.IsZero() {
+		loadbalancerAmphoraCertExpiration.WithLabelValues(labels...).Set(float64(amphora.CertExpiration.Unix()))
+	}
+	loadbalancerAmphoraCreatedAt.WithLabelValues(labels...).Set(float64(amphora.CreatedAt.Unix()))
+	if !amphora.UpdatedAt.IsZero() {
+		loadbalancerAmphoraUpdatedAt.WithLabelValues(labels...).Set(float64(amphora.UpdatedAt.Unix()))
+	}
+
+	for _, status := range amphoraStates {
+		statusLabels := append(labels, status)
+		loadbalancerAmphoraStatus.WithLabelValues(statusLabels...).Set(boolFloat64(amphora.Status == status))
+	}
+}
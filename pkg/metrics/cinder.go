@@ -7,14 +7,12 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/quotasets"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v2/volumes"
 	"github.com/prometheus/client_golang/prometheus"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
 var (
 	// labels which get applied to the most metrics
-	defaultLabels = []string{"id", "description", "name", "status", "cinder_availability_zone", "volume_type", "pvc_name", "pvc_namespace", "pv_name", "pv_storage_class", "pv_reclaim_policy", "pv_fs_type"}
+	defaultLabels = []string{"id", "description", "name", "status", "cinder_availability_zone", "volume_type", "pvc_name", "pvc_namespace", "pv_name", "pv_storage_class", "pv_reclaim_policy", "pv_fs_type", "k8s_cluster", "owner_cluster"}
 
 	// possible cinder states, from https://github.com/openstack/cinder/blob/master/cinder/objects/fields.py#L168
 	cinderStates = []string{"creating", "available", "deleting", "error", "error_deleting", "error_managing", "managing", "attaching", "in-use", "detaching", "maintenance", "restoring-backup", "error_restoring", "reserved", "awaiting-transfer", "backing-up", "error_backing-up", "error_extending", "downloading", "uploading", "retyping", "extending"}
@@ -26,6 +24,7 @@ var (
 	cinderVolumeStatus         *prometheus.GaugeVec
 	cinderVolumeSize           *prometheus.GaugeVec
 	cinderVolumeAttachedAt     *prometheus.GaugeVec
+	pvMissingBackend           *prometheus.GaugeVec
 )
 
 func registerCinderMetrics() {
@@ -80,6 +79,14 @@ func registerCinderMetrics() {
 		append(defaultLabels, "server_id", "device", "hostname"),
 	)
 
+	pvMissingBackend = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("pv_missing_backend"),
+			Help: "Kubernetes pv whose cinder volume does not exist",
+		},
+		[]string{"volume_id", "pv_name", "pvc_name", "pvc_namespace", "pv_storage_class", "k8s_cluster"},
+	)
+
 	prometheus.MustRegister(cinderQuotaVolumes)
 	prometheus.MustRegister(cinderQuotaVolumesGigabyte)
 	prometheus.MustRegister(cinderVolumeCreated)
@@ -87,34 +94,28 @@ func registerCinderMetrics() {
 	prometheus.MustRegister(cinderVolumeSize)
 	prometheus.MustRegister(cinderVolumeStatus)
 	prometheus.MustRegister(cinderVolumeAttachedAt)
+	prometheus.MustRegister(pvMissingBackend)
 }
 
 // PublishCinderMetrics makes the list request to the blockstorage api and passes
 // the result to a publish function.
-func PublishCinderMetrics(client *gophercloud.ServiceClient, clientset *kubernetes.Clientset, tenantID string) error {
+func PublishCinderMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, clusters []*KubernetesCache, tenantID string) error {
 	// first step: gather the data
 
 	// get the cinder pvs to add metadata
-	pvs, err := getPVsByCinderID(clientset)
+	pvs, err := getPVsByCinderID(clusters)
 	if err != nil {
 		return err
 	}
 
 	// get all volumes from openstack
-	mc := newOpenStackMetric("volume", "list")
-	pages, err := volumes.List(client, volumes.ListOpts{}).AllPages()
-	if mc.Observe(err) != nil {
-		// only warn, maybe the next list will work.
-		klog.Warningf("Unable to list volumes: %v", err)
-		return err
-	}
-	volumesList, err := volumes.ExtractVolumes(pages)
+	volumesList, err := resources.Volumes()
 	if err != nil {
 		return err
 	}
 
 	// get quotas form openstack
-	mc = newOpenStackMetric("volume_quotasets_usage", "get")
+	mc := newOpenStackMetric("volume_quotasets_usage", "get")
 	quotas, err := quotasets.GetUsage(client, tenantID).Extract()
 	if mc.Observe(err) != nil {
 		// only warn, maybe the next get will work.
@@ -129,9 +130,11 @@ func PublishCinderMetrics(client *gophercloud.ServiceClient, clientset *kubernet
 	cinderVolumeStatus.Reset()
 	cinderVolumeSize.Reset()
 	cinderVolumeAttachedAt.Reset()
+	pvMissingBackend.Reset()
 
 	// third step: publish the metrics
-	publishVolumes(volumesList, pvs)
+	publishVolumes(filterVolumes(volumesList), pvs)
+	publishPVsMissingBackend(volumesList, pvs)
 
 	publishCinderQuotas(quotas)
 	return nil
@@ -151,7 +154,7 @@ func publishCinderQuotas(q quotasets.QuotaUsageSet) {
 }
 
 // publishVolumes iterates over a page, the result of a list request
-func publishVolumes(vList []volumes.Volume, pvs map[string]corev1.PersistentVolume) {
+func publishVolumes(vList []volumes.Volume, pvs map[string]clusterPV) {
 	for _, v := range vList {
 		if pv, ok := pvs[v.ID]; ok {
 			publishVolumeMetrics(v, &pv)
@@ -161,8 +164,28 @@ func publishVolumes(vList []volumes.Volume, pvs map[string]corev1.PersistentVolu
 	}
 }
 
+// publishPVsMissingBackend exposes the pvs whose cinder volume does not exist
+func publishPVsMissingBackend(vList []volumes.Volume, pvs map[string]clusterPV) {
+	volumeIDs := map[string]bool{}
+	for _, v := range vList {
+		volumeIDs[v.ID] = true
+	}
+
+	for id, pv := range pvs {
+		if volumeIDs[id] {
+			continue
+		}
+		var claimName, claimNamespace string
+		if pv.Spec.ClaimRef != nil {
+			claimName = pv.Spec.ClaimRef.Name
+			claimNamespace = pv.Spec.ClaimRef.Namespace
+		}
+		pvMissingBackend.WithLabelValues(id, pv.GetName(), claimName, claimNamespace, pv.Spec.StorageClassName, pv.cluster).Set(1)
+	}
+}
+
 // publishVolumeMetrics extracts data from a volume and exposes the metrics via prometheus
-func publishVolumeMetrics(v volumes.Volume, pv *corev1.PersistentVolume) {
+func publishVolumeMetrics(v volumes.Volume, pv *clusterPV) {
 	labels := []string{v.ID, v.Description, v.Name, v.Status, v.AvailabilityZone, v.VolumeType}
 
 	k8sMetadata := extractK8sMetadata(pv)
@@ -170,6 +193,7 @@ func publishVolumeMetrics(v volumes.Volume, pv *corev1.PersistentVolume) {
 	// add the k8s specific labels.
 	// We do not need to check them, because the empty string is totally fine.
 	labels = append(labels, k8sMetadata...)
+	labels = append(labels, volumeCluster(v))
 
 	// set the volume-specific metrics
 	cinderVolumeCreated.WithLabelValues(labels...).Set(float64(v.CreatedAt.Unix()))
@@ -192,6 +216,7 @@ func publishVolumeMetrics(v volumes.Volume, pv *corev1.PersistentVolume) {
 	for _, status := range cinderStates {
 		labels := []string{v.ID, v.Description, v.Name, status, v.AvailabilityZone, v.VolumeType}
 		labels = append(labels, k8sMetadata...)
+		labels = append(labels, volumeCluster(v))
 		cinderVolumeStatus.WithLabelValues(labels...).Set(boolFloat64(v.Status == status))
 	}
 }
@@ -3,48 +3,140 @@
 package metrics
 
 import (
-	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/klog/v2"
 )
 
 const (
-	cinderCSIDriver = "cinder.csi.openstack.org"
+	// DefaultCinderCSIDriver is the default name of the cinder csi driver
+	DefaultCinderCSIDriver = "cinder.csi.openstack.org"
+	// attacher of the volume attachments of the in-tree cinder plugin
+	cinderInTreeAttacher = "kubernetes.io/cinder"
+
+	// annotation of cloud-provider-openstack with the id of the load balancer of a service
+	loadBalancerIDAnnotation = "loadbalancer.openstack.org/load-balancer-id"
+	// prefix of the load balancer names created by cloud-provider-openstack
+	loadBalancerNamePrefix = "kube_service_"
+	// prefix of the provider id of the nodes, followed by the optional region and the server id
+	nodeProviderIDPrefix = "openstack://"
+	// prefix of the node labels with the roles of a node, the role is the suffix
+	nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+	// legacy node label with the role of a node as value
+	nodeRoleLabel = "kubernetes.io/role"
 )
 
-func getPVsByCinderID(clientset *kubernetes.Clientset) (map[string]corev1.PersistentVolume, error) {
-	pvsList, err := clientset.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("unable to list pvs: %s", err)
-	}
+var (
+	// DefaultManilaCSIDrivers are the default names of the manila csi drivers, one per share protocol
+	DefaultManilaCSIDrivers = []string{"nfs.manila.csi.openstack.org", "cephfs.manila.csi.openstack.org"}
+
+	cinderCSIDrivers = []string{DefaultCinderCSIDriver}
+	manilaCSIDrivers = DefaultManilaCSIDrivers
+)
 
-	pvs := map[string]corev1.PersistentVolume{}
-	for _, pv := range pvsList.Items {
-		if pv.Spec.Cinder != nil {
-			pvs[pv.Spec.Cinder.VolumeID] = pv
-		} else if pv.Spec.CSI != nil {
-			if pv.Spec.CSI.Driver == cinderCSIDriver {
-				pvs[pv.Spec.CSI.VolumeHandle] = pv
+// SetCSIDrivers sets the names of the cinder and manila csi drivers, whose
+// pvs are matched with the volumes and shares.
+func SetCSIDrivers(cinder, manila []string) {
+	cinderCSIDrivers = cinder
+	manilaCSIDrivers = manila
+}
+
+// clusterObjectName is the name of a cluster scoped kubernetes object, which
+// is only unique within its cluster
+type clusterObjectName struct {
+	cluster string
+	name    string
+}
+
+// clusterPV is a pv with the name of its kubernetes cluster
+type clusterPV struct {
+	corev1.PersistentVolume
+	cluster string
+}
+
+// clusterService is a service with the name of its kubernetes cluster
+type clusterService struct {
+	corev1.Service
+	cluster string
+}
+
+// clusterNode is a node with the name of its kubernetes cluster
+type clusterNode struct {
+	corev1.Node
+	cluster string
+}
+
+// getPVsByCinderID returns the pvs of the in-tree cinder plugin and the cinder
+// csi drivers of all clusters by the id of their volume.
+func getPVsByCinderID(clusters []*KubernetesCache) (map[string]clusterPV, error) {
+	pvs := map[string]clusterPV{}
+	for _, k8s := range clusters {
+		pvsList, err := k8s.pvs.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("unable to list pvs of cluster %s: %s", k8s.cluster, err)
+		}
+
+		for _, pv := range pvsList {
+			var id string
+			if pv.Spec.Cinder != nil {
+				id = pv.Spec.Cinder.VolumeID
+			} else if pv.Spec.CSI != nil {
+				if !contains(cinderCSIDrivers, pv.Spec.CSI.Driver) {
+					klog.V(8).Infof("ignoring pv %s: unimplemented csi-driver", pv.GetName())
+					continue
+				}
+				id = pv.Spec.CSI.VolumeHandle
 			} else {
-				klog.V(8).Infof("ignoring pv %s: unimplemented csi-driver", pv.GetName())
+				klog.V(8).Infof("ignoring pv %s: unimplemented volume plugin", pv.GetName())
+				continue
 			}
-		} else {
-			klog.V(8).Infof("ignoring pv %s: unimplemented volume plugin", pv.GetName())
+			addClusterPV(pvs, id, pv, k8s.cluster)
 		}
 	}
 
 	return pvs, nil
 }
 
+// getPVsByManilaID returns the pvs of the manila csi drivers of all clusters
+// by the id of their share
+func getPVsByManilaID(clusters []*KubernetesCache) (map[string]clusterPV, error) {
+	pvs := map[string]clusterPV{}
+	for _, k8s := range clusters {
+		pvsList, err := k8s.pvs.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("unable to list pvs of cluster %s: %s", k8s.cluster, err)
+		}
+
+		for _, pv := range pvsList {
+			if pv.Spec.CSI != nil && contains(manilaCSIDrivers, pv.Spec.CSI.Driver) {
+				addClusterPV(pvs, pv.Spec.CSI.VolumeHandle, pv, k8s.cluster)
+			}
+		}
+	}
+
+	return pvs, nil
+}
+
+// addClusterPV adds a pv to the pvs by id. If several clusters use the same
+// volume, the pv of the first cluster is kept.
+func addClusterPV(pvs map[string]clusterPV, id string, pv *corev1.PersistentVolume, cluster string) {
+	if existing, ok := pvs[id]; ok {
+		klog.V(4).Infof("ignoring pv %s of cluster %s: volume %s is already used by pv %s of cluster %s", pv.GetName(), cluster, id, existing.GetName(), existing.cluster)
+		return
+	}
+	pvs[id] = clusterPV{PersistentVolume: *pv, cluster: cluster}
+}
+
 // extractK8sMetadata tries to extract the following data from a pv:
-// "pvc_name", "pvc_namespace", "pv_name", "storage_class", "reclaim_policy", "fs_type"
-func extractK8sMetadata(pv *corev1.PersistentVolume) []string {
+// "pvc_name", "pvc_namespace", "pv_name", "storage_class", "reclaim_policy", "fs_type", "k8s_cluster"
+func extractK8sMetadata(pv *clusterPV) []string {
 	if pv == nil {
-		return []string{"", "", "", "", "", ""}
+		return []string{"", "", "", "", "", "", ""}
 	}
 
 	var fsType string
@@ -67,5 +159,136 @@ func extractK8sMetadata(pv *corev1.PersistentVolume) []string {
 		pv.Spec.StorageClassName,
 		string(pv.Spec.PersistentVolumeReclaimPolicy),
 		fsType,
+		pv.cluster,
+	}
+}
+
+// getLoadBalancerServices returns the services of type LoadBalancer of all
+// clusters, which are handled by the default load balancer implementation.
+func getLoadBalancerServices(clusters []*KubernetesCache) ([]clusterService, error) {
+	var services []clusterService
+	for _, k8s := range clusters {
+		servicesList, err := k8s.services.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("unable to list services of cluster %s: %s", k8s.cluster, err)
+		}
+
+		for _, svc := range servicesList {
+			if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+				continue
+			}
+			if svc.Spec.LoadBalancerClass != nil {
+				klog.V(8).Infof("ignoring service %s/%s: load balancer class %s", svc.Namespace, svc.Name, *svc.Spec.LoadBalancerClass)
+				continue
+			}
+			services = append(services, clusterService{Service: *svc, cluster: k8s.cluster})
+		}
 	}
+
+	return services, nil
+}
+
+// parseLoadBalancerName returns the cluster, namespace and name of the service
+// from the name of a load balancer created by cloud-provider-openstack:
+// kube_service_<cluster>_<namespace>_<service>. Namespaces and services can
+// not contain underscores, the cluster name can.
+func parseLoadBalancerName(name string) (cluster, namespace, service string, ok bool) {
+	if !strings.HasPrefix(name, loadBalancerNamePrefix) {
+		return "", "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(name, loadBalancerNamePrefix), "_")
+	if len(parts) < 3 {
+		return "", "", "", false
+	}
+	n := len(parts)
+	return strings.Join(parts[:n-2], "_"), parts[n-2], parts[n-1], true
+}
+
+// extractServiceMetadata tries to extract the following data from a service:
+// "service_name", "service_namespace"
+func extractServiceMetadata(svc *clusterService) []string {
+	if svc == nil {
+		return []string{"", ""}
+	}
+	return []string{svc.GetName(), svc.GetNamespace()}
+}
+
+// getNodesByServerID returns the nodes of all clusters by the id of their
+// server, which is part of the provider id: openstack:///<id> or
+// openstack://<region>/<id>. If several nodes have the same
+// server, the first node is kept.
+func getNodesByServerID(clusters []*KubernetesCache) (map[string]clusterNode, error) {
+	nodes := map[string]clusterNode{}
+	for _, k8s := range clusters {
+		nodesList, err := k8s.nodes.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("unable to list nodes of cluster %s: %s", k8s.cluster, err)
+		}
+
+		for _, node := range nodesList {
+			if !strings.HasPrefix(node.Spec.ProviderID, nodeProviderIDPrefix) {
+				klog.V(8).Infof("ignoring node %s: unimplemented provider id %q", node.GetName(), node.Spec.ProviderID)
+				continue
+			}
+			id := node.Spec.ProviderID[strings.LastIndex(node.Spec.ProviderID, "/")+1:]
+			if existing, ok := nodes[id]; ok {
+				klog.V(4).Infof("ignoring node %s of cluster %s: server %s is already used by node %s of cluster %s", node.GetName(), k8s.cluster, id, existing.GetName(), existing.cluster)
+				continue
+			}
+			nodes[id] = clusterNode{Node: *node, cluster: k8s.cluster}
+		}
+	}
+
+	return nodes, nil
+}
+
+// extractNodeMetadata tries to extract the following data from a node:
+// "node", "node_role", "k8s_cluster"
+func extractNodeMetadata(node *clusterNode) []string {
+	if node == nil {
+		return []string{"", "", ""}
+	}
+
+	var roles []string
+	for label := range node.Labels {
+		if strings.HasPrefix(label, nodeRoleLabelPrefix) {
+			roles = append(roles, strings.TrimPrefix(label, nodeRoleLabelPrefix))
+		}
+	}
+	if role := node.Labels[nodeRoleLabel]; role != "" && len(roles) == 0 {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	return []string{node.GetName(), strings.Join(roles, ","), node.cluster}
+}
+
+// isNodeReady returns true if the ready condition of the node is true
+func isNodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// getCinderVolumeAttachments returns the volume attachments of the in-tree
+// cinder plugin and the cinder csi driver of a cluster.
+func getCinderVolumeAttachments(k8s *KubernetesCache) ([]storagev1.VolumeAttachment, error) {
+	vaList, err := k8s.volumeAttachments.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("unable to list volume attachments of cluster %s: %s", k8s.cluster, err)
+	}
+
+	var vas []storagev1.VolumeAttachment
+	for _, va := range vaList {
+		if !contains(cinderCSIDrivers, va.Spec.Attacher) && va.Spec.Attacher != cinderInTreeAttacher {
+			klog.V(8).Infof("ignoring volume attachment %s: unimplemented attacher", va.GetName())
+			continue
+		}
+		vas = append(vas, *va)
+	}
+
+	return vas, nil
 }
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestParseLoadBalancerName(t *testing.T) {
+	tests := []struct {
+		name      string
+		cluster   string
+		namespace string
+		service   string
+		ok        bool
+	}{
+		{name: "kube_service_kubernetes_default_web", cluster: "kubernetes", namespace: "default", service: "web", ok: true},
+		{name: "kube_service_prod_eu_1_ingress_nginx", cluster: "prod_eu_1", namespace: "ingress", service: "nginx", ok: true},
+		{name: "kube_service__default_web", cluster: "", namespace: "default", service: "web", ok: true},
+		{name: "kube_service_default_web", ok: false},
+		{name: "kube_service_", ok: false},
+		{name: "my_service_kubernetes_default_web", ok: false},
+		{name: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, namespace, service, ok := parseLoadBalancerName(tt.name)
+			if ok != tt.ok || cluster != tt.cluster || namespace != tt.namespace || service != tt.service {
+				t.Errorf("parseLoadBalancerName(%q) = %q, %q, %q, %v, want %q, %q, %q, %v",
+					tt.name, cluster, namespace, service, ok, tt.cluster, tt.namespace, tt.service, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAddClusterPV(t *testing.T) {
+	type addedPV struct {
+		id      string
+		pv      string
+		cluster string
+	}
+	tests := []struct {
+		name  string
+		added []addedPV
+		want  map[string]clusterObjectName
+	}{
+		{
+			name:  "single pv",
+			added: []addedPV{{id: "vol-1", pv: "pv-a", cluster: "prod"}},
+			want:  map[string]clusterObjectName{"vol-1": {cluster: "prod", name: "pv-a"}},
+		},
+		{
+			name: "different volumes",
+			added: []addedPV{
+				{id: "vol-1", pv: "pv-a", cluster: "prod"},
+				{id: "vol-2", pv: "pv-b", cluster: "stage"},
+			},
+			want: map[string]clusterObjectName{
+				"vol-1": {cluster: "prod", name: "pv-a"},
+				"vol-2": {cluster: "stage", name: "pv-b"},
+			},
+		},
+		{
+			name: "same volume in several clusters keeps the first",
+			added: []addedPV{
+				{id: "vol-1", pv: "pv-a", cluster: "prod"},
+				{id: "vol-1", pv: "pv-b", cluster: "stage"},
+			},
+			want: map[string]clusterObjectName{"vol-1": {cluster: "prod", name: "pv-a"}},
+		},
+		{
+			name: "same volume in one cluster keeps the first",
+			added: []addedPV{
+				{id: "vol-1", pv: "pv-a", cluster: "prod"},
+				{id: "vol-1", pv: "pv-b", cluster: "prod"},
+			},
+			want: map[string]clusterObjectName{"vol-1": {cluster: "prod", name: "pv-a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvs := map[string]clusterPV{}
+			for _, added := range tt.added {
+				pv := &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: added.pv}}
+				addClusterPV(pvs, added.id, pv, added.cluster)
+			}
+
+			if len(pvs) != len(tt.want) {
+				t.Fatalf("got %d pvs, want %d", len(pvs), len(tt.want))
+			}
+			for id, want := range tt.want {
+				got, ok := pvs[id]
+				if !ok {
+					t.Errorf("pv of volume %s is missing", id)
+					continue
+				}
+				if got.cluster != want.cluster || got.GetName() != want.name {
+					t.Errorf("pv of volume %s = %s/%s, want %s/%s", id, got.cluster, got.GetName(), want.cluster, want.name)
+				}
+			}
+		})
+	}
+}
+
+func TestGetNodesByServerID(t *testing.T) {
+	newCache := func(cluster string, nodes ...*corev1.Node) *KubernetesCache {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		for _, node := range nodes {
+			if err := indexer.Add(node); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return &KubernetesCache{cluster: cluster, nodes: corelisters.NewNodeLister(indexer)}
+	}
+	newNode := func(name, providerID string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{ProviderID: providerID},
+		}
+	}
+
+	clusters := []*KubernetesCache{
+		newCache("prod",
+			newNode("prod-1", "openstack:///srv-1"),
+			newNode("prod-2", "openstack://region/srv-2"),
+			newNode("prod-3", "aws:///srv-3"),
+		),
+		newCache("stage",
+			newNode("stage-1", "openstack:///srv-1"),
+			newNode("stage-4", "openstack:///srv-4"),
+		),
+	}
+	want := map[string]clusterObjectName{
+		"srv-1": {cluster: "prod", name: "prod-1"},
+		"srv-2": {cluster: "prod", name: "prod-2"},
+		"srv-4": {cluster: "stage", name: "stage-4"},
+	}
+
+	nodes, err := getNodesByServerID(clusters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(nodes), len(want))
+	}
+	for id, want := range want {
+		got, ok := nodes[id]
+		if !ok {
+			t.Errorf("node of server %s is missing", id)
+			continue
+		}
+		if got.cluster != want.cluster || got.GetName() != want.name {
+			t.Errorf("node of server %s = %s/%s, want %s/%s", id, got.cluster, got.GetName(), want.cluster, want.name)
+		}
+	}
+}
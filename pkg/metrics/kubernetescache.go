@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// KubernetesCache holds the kubernetes objects which get correlated with the
+// openstack resources. The objects are kept up to date by shared informers,
+// so they do not need to be listed from the api server on every scrape.
+// There is one cache per cluster, no cache is used if the kubernetes
+// integration is disabled.
+type KubernetesCache struct {
+	// cluster is the name of the cluster, it is exposed as k8s_cluster label
+	cluster string
+	factory informers.SharedInformerFactory
+
+	pvs               corelisters.PersistentVolumeLister
+	services          corelisters.ServiceLister
+	nodes             corelisters.NodeLister
+	volumeAttachments storagelisters.VolumeAttachmentLister
+
+	// synced holds the sync status function of the informer of every resource
+	synced map[string]cache.InformerSynced
+}
+
+// NewKubernetesCache creates the informers for the pvs, services, nodes and
+// volume attachments of a cluster. They are started by Start.
+func NewKubernetesCache(cluster string, clientset kubernetes.Interface, resync time.Duration) *KubernetesCache {
+	factory := informers.NewSharedInformerFactory(clientset, resync)
+
+	pvs := factory.Core().V1().PersistentVolumes()
+	services := factory.Core().V1().Services()
+	nodes := factory.Core().V1().Nodes()
+	volumeAttachments := factory.Storage().V1().VolumeAttachments()
+
+	return &KubernetesCache{
+		cluster:           cluster,
+		factory:           factory,
+		pvs:               pvs.Lister(),
+		services:          services.Lister(),
+		nodes:             nodes.Lister(),
+		volumeAttachments: volumeAttachments.Lister(),
+		synced: map[string]cache.InformerSynced{
+			"persistentvolumes": pvs.Informer().HasSynced,
+			"services":          services.Informer().HasSynced,
+			"nodes":             nodes.Informer().HasSynced,
+			"volumeattachments": volumeAttachments.Informer().HasSynced,
+		},
+	}
+}
+
+// Cluster returns the name of the cluster.
+func (c *KubernetesCache) Cluster() string {
+	return c.cluster
+}
+
+// Start starts the informers, they run until stopCh is closed.
+func (c *KubernetesCache) Start(stopCh <-chan struct{}) {
+	c.factory.Start(stopCh)
+}
+
+// WaitForSync waits until the informers have synced or stopCh is closed and
+// returns false, if an informer has not synced.
+func (c *KubernetesCache) WaitForSync(stopCh <-chan struct{}) bool {
+	synced := make([]cache.InformerSynced, 0, len(c.synced))
+	for _, hasSynced := range c.synced {
+		synced = append(synced, hasSynced)
+	}
+	return cache.WaitForCacheSync(stopCh, synced...)
+}
+
+// HasSynced returns true if the informers of all resources have synced.
+func (c *KubernetesCache) HasSynced() bool {
+	for _, hasSynced := range c.synced {
+		if !hasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncStatus returns the sync status of the informer of every resource.
+func (c *KubernetesCache) SyncStatus() map[string]bool {
+	status := map[string]bool{}
+	for resource, hasSynced := range c.synced {
+		status[resource] = hasSynced()
+	}
+	return status
+}
@@ -0,0 +1,347 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	loadbalancerListenerInfo                    *prometheus.GaugeVec
+	loadbalancerListenerProvisioningStatus      *prometheus.GaugeVec
+	loadbalancerListenerConnectionLimit         *prometheus.GaugeVec
+	loadbalancerListenerTimeout                 *prometheus.GaugeVec
+	loadbalancerListenerSNIContainers           *prometheus.GaugeVec
+	loadbalancerL7PolicyProvisioningStatus      *prometheus.GaugeVec
+	loadbalancerL7PolicyOperatingStatus         *prometheus.GaugeVec
+	loadbalancerL7RuleProvisioningStatus        *prometheus.GaugeVec
+	loadbalancerL7RuleOperatingStatus           *prometheus.GaugeVec
+	loadbalancerHealthMonitorInfo               *prometheus.GaugeVec
+	loadbalancerHealthMonitorDelay              *prometheus.GaugeVec
+	loadbalancerHealthMonitorTimeout            *prometheus.GaugeVec
+	loadbalancerHealthMonitorMaxRetries         *prometheus.GaugeVec
+	loadbalancerHealthMonitorMaxRetriesDown     *prometheus.GaugeVec
+	loadbalancerHealthMonitorProvisioningStatus *prometheus.GaugeVec
+	loadbalancerHealthMonitorOperatingStatus    *prometheus.GaugeVec
+
+	l7PolicyLabels      = []string{"l7policy_id", "l7policy_name", "action"}
+	l7RuleLabels        = []string{"l7rule_id", "type", "compare_type"}
+	healthMonitorLabels = []string{"healthmonitor_id", "healthmonitor_name"}
+)
+
+func registerLoadBalancerListenerMetrics() {
+	loadbalancerListenerInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_listener_info"),
+			Help: "Load balancer listener information",
+		},
+		append(append(loadBalancerLabels, listenerLabels...), "protocol", "protocol_port", "default_pool_id", "default_tls_container_ref"),
+	)
+	loadbalancerListenerProvisioningStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_listener_provisioning_status"),
+			Help: "Load balancer listener provisioning status",
+		},
+		append(append(loadBalancerLabels, listenerLabels...), "listener_provisioning_status"),
+	)
+	loadbalancerListenerConnectionLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_listener_connection_limit"),
+			Help: "Load balancer listener connection limit (-1 is unlimited)",
+		},
+		append(loadBalancerLabels, listenerLabels...),
+	)
+	loadbalancerListenerTimeout = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_listener_timeout_milliseconds"),
+			Help: "Load balancer listener timeouts (in ms)",
+		},
+		append(append(loadBalancerLabels, listenerLabels...), "timeout"),
+	)
+	loadbalancerListenerSNIContainers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_listener_sni_container_refs"),
+			Help: "Number of SNI TLS container refs of a load balancer listener",
+		},
+		append(loadBalancerLabels, listenerLabels...),
+	)
+	loadbalancerL7PolicyProvisioningStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_l7policy_provisioning_status"),
+			Help: "Load balancer L7 policy provisioning status",
+		},
+		append(append(append(loadBalancerLabels, listenerLabels...), l7PolicyLabels...), "l7policy_provisioning_status"),
+	)
+	loadbalancerL7PolicyOperatingStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_l7policy_operating_status"),
+			Help: "Load balancer L7 policy operating status",
+		},
+		append(append(append(loadBalancerLabels, listenerLabels...), l7PolicyLabels...), "l7policy_operating_status"),
+	)
+	loadbalancerL7RuleProvisioningStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_l7rule_provisioning_status"),
+			Help: "Load balancer L7 rule provisioning status",
+		},
+		append(append(append(append(loadBalancerLabels, listenerLabels...), l7PolicyLabels...), l7RuleLabels...), "l7rule_provisioning_status"),
+	)
+	loadbalancerL7RuleOperatingStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_l7rule_operating_status"),
+			Help: "Load balancer L7 rule operating status",
+		},
+		append(append(append(append(loadBalancerLabels, listenerLabels...), l7PolicyLabels...), l7RuleLabels...), "l7rule_operating_status"),
+	)
+	loadbalancerHealthMonitorInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_healthmonitor_info"),
+			Help: "Load balancer health monitor information",
+		},
+		append(append(append(loadBalancerLabels, poolLabels...), healthMonitorLabels...), "type", "http_method", "url_path", "expected_codes"),
+	)
+	loadbalancerHealthMonitorDelay = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_healthmonitor_delay_seconds"),
+			Help: "Load balancer health monitor delay between the checks",
+		},
+		append(append(loadBalancerLabels, poolLabels...), healthMonitorLabels...),
+	)
+	loadbalancerHealthMonitorTimeout = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_healthmonitor_timeout_seconds"),
+			Help: "Load balancer health monitor timeout of a check",
+		},
+		append(append(loadBalancerLabels, poolLabels...), healthMonitorLabels...),
+	)
+	loadbalancerHealthMonitorMaxRetries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_healthmonitor_max_retries"),
+			Help: "Load balancer health monitor successful checks before a member is online",
+		},
+		append(append(loadBalancerLabels, poolLabels...), healthMonitorLabels...),
+	)
+	loadbalancerHealthMonitorMaxRetriesDown = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_healthmonitor_max_retries_down"),
+			Help: "Load balancer health monitor failed checks before a member is offline",
+		},
+		append(append(loadBalancerLabels, poolLabels...), healthMonitorLabels...),
+	)
+	loadbalancerHealthMonitorProvisioningStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_healthmonitor_provisioning_status"),
+			Help: "Load balancer health monitor provisioning status",
+		},
+		append(append(append(loadBalancerLabels, poolLabels...), healthMonitorLabels...), "healthmonitor_provisioning_status"),
+	)
+	loadbalancerHealthMonitorOperatingStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_healthmonitor_operating_status"),
+			Help: "Load balancer health monitor operating status",
+		},
+		append(append(append(loadBalancerLabels, poolLabels...), healthMonitorLabels...), "healthmonitor_operating_status"),
+	)
+
+	prometheus.MustRegister(loadbalancerListenerInfo)
+	prometheus.MustRegister(loadbalancerListenerProvisioningStatus)
+	prometheus.MustRegister(loadbalancerListenerConnectionLimit)
+	prometheus.MustRegister(loadbalancerListenerTimeout)
+	prometheus.MustRegister(loadbalancerListenerSNIContainers)
+	prometheus.MustRegister(loadbalancerL7PolicyProvisioningStatus)
+	prometheus.MustRegister(loadbalancerL7PolicyOperatingStatus)
+	prometheus.MustRegister(loadbalancerL7RuleProvisioningStatus)
+	prometheus.MustRegister(loadbalancerL7RuleOperatingStatus)
+	prometheus.MustRegister(loadbalancerHealthMonitorInfo)
+	prometheus.MustRegister(loadbalancerHealthMonitorDelay)
+	prometheus.MustRegister(loadbalancerHealthMonitorTimeout)
+	prometheus.MustRegister(loadbalancerHealthMonitorMaxRetries)
+	prometheus.MustRegister(loadbalancerHealthMonitorMaxRetriesDown)
+	prometheus.MustRegister(loadbalancerHealthMonitorProvisioningStatus)
+	prometheus.MustRegister(loadbalancerHealthMonitorOperatingStatus)
+}
+
+// publishLoadBalancerListenerMetrics makes the list requests for the listeners,
+// L7 policies and health monitors and passes the result to the publish functions.
+// The load balancers and pools are used to add the load balancer labels.
+func publishLoadBalancerListenerMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, loadBalancerList []loadBalancerWithService, poolList []pools.Pool) error {
+	// first step: gather the data
+	listenerList, err := resources.Listeners()
+	if err != nil {
+		return err
+	}
+
+	l7PolicyList, err := resources.L7Policies()
+	if err != nil {
+		return err
+	}
+	monitorList, err := resources.HealthMonitors()
+	if err != nil {
+		return err
+	}
+
+	loadBalancersByID := map[string]loadBalancerWithService{}
+	for _, lb := range loadBalancerList {
+		loadBalancersByID[lb.ID] = lb
+	}
+	poolsByID := map[string]pools.Pool{}
+	for _, pool := range poolList {
+		poolsByID[pool.ID] = pool
+	}
+
+	// second step: reset the old metrics
+	loadbalancerListenerInfo.Reset()
+	loadbalancerListenerProvisioningStatus.Reset()
+	loadbalancerListenerConnectionLimit.Reset()
+	loadbalancerListenerTimeout.Reset()
+	loadbalancerListenerSNIContainers.Reset()
+	loadbalancerL7PolicyProvisioningStatus.Reset()
+	loadbalancerL7PolicyOperatingStatus.Reset()
+	loadbalancerL7RuleProvisioningStatus.Reset()
+	loadbalancerL7RuleOperatingStatus.Reset()
+	loadbalancerHealthMonitorInfo.Reset()
+	loadbalancerHealthMonitorDelay.Reset()
+	loadbalancerHealthMonitorTimeout.Reset()
+	loadbalancerHealthMonitorMaxRetries.Reset()
+	loadbalancerHealthMonitorMaxRetriesDown.Reset()
+	loadbalancerHealthMonitorProvisioningStatus.Reset()
+	loadbalancerHealthMonitorOperatingStatus.Reset()
+
+	// third step: publish the metrics
+	listenersByID := map[string]listeners.Listener{}
+	for _, listener := range listenerList {
+		listenersByID[listener.ID] = listener
+		for _, lbID := range listener.Loadbalancers {
+			if lb, ok := loadBalancersByID[lbID.ID]; ok {
+				publishListenerMetric(lb, listener)
+			}
+		}
+	}
+
+	for _, monitor := range monitorList {
+		for _, poolID := range monitor.Pools {
+			pool, ok := poolsByID[poolID.ID]
+			if !ok {
+				continue
+			}
+			for _, lbID := range pool.Loadbalancers {
+				if lb, ok := loadBalancersByID[lbID.ID]; ok {
+					publishHealthMonitorMetric(lb, pool, monitor)
+				}
+			}
+		}
+	}
+
+	for _, policy := range l7PolicyList {
+		listener, ok := listenersByID[policy.ListenerID]
+		if !ok {
+			continue
+		}
+
+		// the rules of the list response only contain the id, so the rules are
+		// only listed for the policies which have rules. A policy may be deleted
+		// after it was listed and a failed request does not fail the scrape.
+		var rules []l7policies.Rule
+		if len(policy.Rules) > 0 {
+			mc := newOpenStackMetric("loadbalancer_l7rule", "list")
+			pages, err := l7policies.ListRules(client, policy.ID, l7policies.ListRulesOpts{}).AllPages()
+			if mc.Observe(err) != nil {
+				if _, ok := err.(gophercloud.ErrDefault404); ok {
+					klog.V(4).Infof("skipping l7 policy %s as it does not exist anymore", policy.ID)
+					continue
+				}
+				// only warn, the failed request is counted by the openstack metrics
+				klog.Warningf("Unable to list rules of l7 policy %s: %v", policy.ID, err)
+				continue
+			}
+			rules, err = l7policies.ExtractRules(pages)
+			if err != nil {
+				klog.Warningf("Unable to extract rules of l7 policy %s: %v", policy.ID, err)
+				continue
+			}
+		}
+
+		for _, lbID := range listener.Loadbalancers {
+			if lb, ok := loadBalancersByID[lbID.ID]; ok {
+				publishL7PolicyMetric(lb, listener, policy, rules)
+			}
+		}
+	}
+
+	return nil
+}
+
+// publishListenerMetric extracts data from a listener and exposes the metrics via prometheus
+func publishListenerMetric(lb loadBalancerWithService, listener listeners.Listener) {
+	labels := append(lb.labelValues(), listener.ID, listener.Name)
+
+	infoLabels := append(labels, listener.Protocol, strconv.Itoa(listener.ProtocolPort), listener.DefaultPoolID, listener.DefaultTlsContainerRef)
+	loadbalancerListenerInfo.WithLabelValues(infoLabels...).Set(1)
+	loadbalancerListenerConnectionLimit.WithLabelValues(labels...).Set(float64(listener.ConnLimit))
+	loadbalancerListenerSNIContainers.WithLabelValues(labels...).Set(float64(len(listener.SniContainerRefs)))
+
+	loadbalancerListenerTimeout.WithLabelValues(append(labels, "client_data")...).Set(float64(listener.TimeoutClientData))
+	loadbalancerListenerTimeout.WithLabelValues(append(labels, "member_data")...).Set(float64(listener.TimeoutMemberData))
+	loadbalancerListenerTimeout.WithLabelValues(append(labels, "member_connect")...).Set(float64(listener.TimeoutMemberConnect))
+	loadbalancerListenerTimeout.WithLabelValues(append(labels, "tcp_inspect")...).Set(float64(listener.TimeoutTCPInspect))
+
+	for _, state := range poolProvisioningStates {
+		stateLabels := append(labels, state)
+		loadbalancerListenerProvisioningStatus.WithLabelValues(stateLabels...).Set(boolFloat64(listener.ProvisioningStatus == state))
+	}
+}
+
+// publishL7PolicyMetric extracts data from a L7 policy and its rules and exposes the metrics via prometheus
+func publishL7PolicyMetric(lb loadBalancerWithService, listener listeners.Listener, policy l7policies.L7Policy, rules []l7policies.Rule) {
+	labels := append(lb.labelValues(), listener.ID, listener.Name, policy.ID, policy.Name, policy.Action)
+
+	for _, state := range poolProvisioningStates {
+		stateLabels := append(labels, state)
+		loadbalancerL7PolicyProvisioningStatus.WithLabelValues(stateLabels...).Set(boolFloat64(policy.ProvisioningStatus == state))
+	}
+	for _, state := range operatingStates {
+		stateLabels := append(labels, state)
+		loadbalancerL7PolicyOperatingStatus.WithLabelValues(stateLabels...).Set(boolFloat64(policy.OperatingStatus == state))
+	}
+
+	for _, rule := range rules {
+		ruleLabels := append(labels, rule.ID, rule.RuleType, rule.CompareType)
+		for _, state := range poolProvisioningStates {
+			stateLabels := append(ruleLabels, state)
+			loadbalancerL7RuleProvisioningStatus.WithLabelValues(stateLabels...).Set(boolFloat64(rule.ProvisioningStatus == state))
+		}
+		for _, state := range operatingStates {
+			stateLabels := append(ruleLabels, state)
+			loadbalancerL7RuleOperatingStatus.WithLabelValues(stateLabels...).Set(boolFloat64(rule.OperatingStatus == state))
+		}
+	}
+}
+
+// publishHealthMonitorMetric extracts data from a health monitor and exposes the metrics via prometheus
+func publishHealthMonitorMetric(lb loadBalancerWithService, pool pools.Pool, monitor monitors.Monitor) {
+	labels := append(lb.labelValues(), pool.ID, pool.Name, monitor.ID, monitor.Name)
+
+	infoLabels := append(labels, monitor.Type, monitor.HTTPMethod, monitor.URLPath, monitor.ExpectedCodes)
+	loadbalancerHealthMonitorInfo.WithLabelValues(infoLabels...).Set(1)
+	loadbalancerHealthMonitorDelay.WithLabelValues(labels...).Set(float64(monitor.Delay))
+	loadbalancerHealthMonitorTimeout.WithLabelValues(labels...).Set(float64(monitor.Timeout))
+	loadbalancerHealthMonitorMaxRetries.WithLabelValues(labels...).Set(float64(monitor.MaxRetries))
+	loadbalancerHealthMonitorMaxRetriesDown.WithLabelValues(labels...).Set(float64(monitor.MaxRetriesDown))
+
+	for _, state := range poolProvisioningStates {
+		stateLabels := append(labels, state)
+		loadbalancerHealthMonitorProvisioningStatus.WithLabelValues(stateLabels...).Set(boolFloat64(monitor.ProvisioningStatus == state))
+	}
+	for _, state := range operatingStates {
+		stateLabels := append(labels, state)
+		loadbalancerHealthMonitorOperatingStatus.WithLabelValues(stateLabels...).Set(boolFloat64(monitor.OperatingStatus == state))
+	}
+}
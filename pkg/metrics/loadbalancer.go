@@ -3,6 +3,9 @@
 package metrics
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
@@ -12,9 +15,16 @@ import (
 
 var (
 	loadbalancerAdminStateUp                 *prometheus.GaugeVec
+	loadbalancerInfo                         *prometheus.GaugeVec
 	loadbalancerStatus                       *prometheus.GaugeVec
 	loadbalancerPoolProvisioningStatus       *prometheus.GaugeVec
 	loadbalancerPoolMemberProvisioningStatus *prometheus.GaugeVec
+	loadbalancerOperatingStatus              *prometheus.GaugeVec
+	loadbalancerListenerOperatingStatus      *prometheus.GaugeVec
+	loadbalancerPoolOperatingStatus          *prometheus.GaugeVec
+	loadbalancerPoolMemberOperatingStatus    *prometheus.GaugeVec
+	loadbalancerWithoutService               *prometheus.GaugeVec
+	serviceWithoutLoadBalancer               *prometheus.GaugeVec
 
 	// possible load balancer provisioning states, from https://github.com/openstack/octavia-lib/blob/fe022cdf14604206af783c8a0887c008c48fd053/octavia_lib/common/constants.py#L169
 	provisioningStates = []string{"ALLOCATED", "BOOTING", "READY", "ACTIVE", "PENDING_DELETE", "PENDING_UPDATE", "PENDING_CREATE", "DELETED", "ERROR"}
@@ -23,7 +33,11 @@ var (
 	// Possible states ACTIVE, PENDING_* or ERROR.
 	poolProvisioningStates = []string{"ACTIVE", "PENDING_DELETE", "PENDING_CREATE", "PENDING_UPDATE", "ERROR"}
 
-	loadBalancerLabels = []string{"id", "name", "vip_address", "provider", "port_id"}
+	// possible operating states, from https://github.com/openstack/octavia-lib/blob/fe022cdf14604206af783c8a0887c008c48fd053/octavia_lib/common/constants.py#L147
+	operatingStates = []string{"ONLINE", "OFFLINE", "DEGRADED", "ERROR", "NO_MONITOR", "DRAINING"}
+
+	loadBalancerLabels = []string{"id", "name", "vip_address", "provider", "port_id", "service_name", "service_namespace", "k8s_cluster", "owner_cluster"}
+	listenerLabels     = []string{"listener_id", "listener_name"}
 	poolLabels         = []string{"pool_id", "pool_name"}
 	poolMemberLabels   = []string{"member_id", "member_name"}
 )
@@ -36,6 +50,13 @@ func registerLoadBalancerMetrics() {
 		},
 		loadBalancerLabels,
 	)
+	loadbalancerInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_info"),
+			Help: "Load balancer information",
+		},
+		append(loadBalancerLabels, "flavor_id", "availability_zone"),
+	)
 	loadbalancerStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: generateName("loadbalancer_provisioning_status"),
@@ -60,94 +81,344 @@ func registerLoadBalancerMetrics() {
 		append(append(append(loadBalancerLabels, poolLabels...), poolMemberLabels...), "pool_member_provisioning_status"),
 	)
 
+	loadbalancerOperatingStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_operating_status"),
+			Help: "Load balancer operating status",
+		},
+		append(loadBalancerLabels, "operating_status"),
+	)
+
+	loadbalancerListenerOperatingStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_listener_operating_status"),
+			Help: "Load balancer listener operating status",
+		},
+		append(append(loadBalancerLabels, listenerLabels...), "listener_operating_status"),
+	)
+
+	loadbalancerPoolOperatingStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_pool_operating_status"),
+			Help: "Load balancer pool operating status",
+		},
+		append(append(loadBalancerLabels, poolLabels...), "pool_operating_status"),
+	)
+
+	loadbalancerPoolMemberOperatingStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_pool_member_operating_status"),
+			Help: "Load balancer pool member operating status",
+		},
+		append(append(append(loadBalancerLabels, poolLabels...), poolMemberLabels...), "pool_member_operating_status"),
+	)
+
+	loadbalancerWithoutService = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_without_service"),
+			Help: "Load balancer created by cloud-provider-openstack whose kubernetes service does not exist",
+		},
+		loadBalancerLabels,
+	)
+
+	serviceWithoutLoadBalancer = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("kubernetes_service_without_loadbalancer"),
+			Help: "Kubernetes service of type LoadBalancer whose load balancer does not exist",
+		},
+		[]string{"service_name", "service_namespace", "k8s_cluster", "loadbalancer_id"},
+	)
+
 	prometheus.MustRegister(loadbalancerAdminStateUp)
+	prometheus.MustRegister(loadbalancerInfo)
 	prometheus.MustRegister(loadbalancerStatus)
 	prometheus.MustRegister(loadbalancerPoolProvisioningStatus)
 	prometheus.MustRegister(loadbalancerPoolMemberProvisioningStatus)
+	prometheus.MustRegister(loadbalancerOperatingStatus)
+	prometheus.MustRegister(loadbalancerListenerOperatingStatus)
+	prometheus.MustRegister(loadbalancerPoolOperatingStatus)
+	prometheus.MustRegister(loadbalancerPoolMemberOperatingStatus)
+	prometheus.MustRegister(loadbalancerWithoutService)
+	prometheus.MustRegister(serviceWithoutLoadBalancer)
 }
 
-// PublishLoadBalancerMetrics makes the list request to the load balancer api and
-// passes the result to a publish function.
-func PublishLoadBalancerMetrics(client *gophercloud.ServiceClient, tenantID string) error {
+// loadBalancerWithService is a load balancer with the kubernetes service it
+// belongs to. The service is nil if it is unknown.
+type loadBalancerWithService struct {
+	loadbalancers.LoadBalancer
+	service *clusterService
+	// cluster is the cluster name of cloud-provider-openstack in the load balancer name
+	cluster string
+}
+
+// labelValues returns the values of the loadBalancerLabels
+func (lb loadBalancerWithService) labelValues() []string {
+	labels := []string{lb.ID, lb.Name, lb.VipAddress, lb.Provider, lb.VipPortID}
+	labels = append(labels, extractServiceMetadata(lb.service)...)
+	var k8sCluster string
+	if lb.service != nil {
+		k8sCluster = lb.service.cluster
+	}
+	return append(labels, k8sCluster, lb.cluster)
+}
+
+// PublishLoadBalancerMetrics makes the list requests to the load balancer api and
+// passes the result to a publish function. The pools are listed at once, the
+// members and their status are read from the status tree of each load balancer.
+// The listeners, L7 policies, health monitors and traffic statistics are
+// published afterwards.
+// The load balancers are matched with the kubernetes services by the
+// annotation or the name cloud-provider-openstack uses.
+func PublishLoadBalancerMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, clusters []*KubernetesCache, tenantID string) error {
 	// first step: gather the data
-	mc := newOpenStackMetric("loadbalancer", "list")
-	pages, err := loadbalancers.List(client, loadbalancers.ListOpts{}).AllPages()
-	if mc.Observe(err) != nil {
-		// only warn, maybe the next list will work.
-		klog.Warningf("Unable to list load balancers: %v", err)
+
+	// get the services to add metadata
+	services, err := getLoadBalancerServices(clusters)
+	if err != nil {
 		return err
 	}
-	loadBalancerList, err := loadbalancers.ExtractLoadBalancers(pages)
+
+	lbs, err := resources.LoadBalancers()
 	if err != nil {
-		// only warn, maybe the next publish will work.
-		klog.Warningf("Unable to extract load balancers: %v", err)
 		return err
 	}
+	loadBalancerList, servicesWithoutLoadBalancer := matchLoadBalancerServices(lbs, services)
+	loadBalancerList = filterLoadBalancers(loadBalancerList)
 	if len(loadBalancerList) == 0 {
 		klog.Info("No load balancers found. Skipping load balancer metrics.")
 	}
 
+	poolList, err := resources.Pools()
+	if err != nil {
+		return err
+	}
+	poolsByLoadBalancer := map[string][]pools.Pool{}
+	for _, pool := range poolList {
+		for _, lb := range pool.Loadbalancers {
+			poolsByLoadBalancer[lb.ID] = append(poolsByLoadBalancer[lb.ID], pool)
+		}
+	}
+
 	// second step: reset the old metrics
 	loadbalancerAdminStateUp.Reset()
+	loadbalancerInfo.Reset()
 	loadbalancerStatus.Reset()
 	loadbalancerPoolProvisioningStatus.Reset()
 	loadbalancerPoolMemberProvisioningStatus.Reset()
+	loadbalancerOperatingStatus.Reset()
+	loadbalancerListenerOperatingStatus.Reset()
+	loadbalancerPoolOperatingStatus.Reset()
+	loadbalancerPoolMemberOperatingStatus.Reset()
+	loadbalancerWithoutService.Reset()
+	serviceWithoutLoadBalancer.Reset()
 
 	// third step: publish the metrics
+	for _, svc := range servicesWithoutLoadBalancer {
+		serviceWithoutLoadBalancer.WithLabelValues(svc.Name, svc.Namespace, svc.cluster, svc.Annotations[loadBalancerIDAnnotation]).Set(1)
+	}
+
+	// a load balancer or pool may be deleted after it was listed, the failed
+	// requests of single items do not fail the scrape
 	for _, lb := range loadBalancerList {
 		publishLoadBalancerMetric(lb)
+		if len(clusters) > 0 && lb.service == nil && strings.HasPrefix(lb.Name, loadBalancerNamePrefix) {
+			loadbalancerWithoutService.WithLabelValues(lb.labelValues()...).Set(1)
+		}
 
-		// for the pools associated with the loadbalancer
-		for _, poolWithOnlyId := range lb.Pools {
-			pool, err := pools.Get(client, poolWithOnlyId.ID).Extract()
-			if err != nil {
-				klog.Warningf("Unable to get pool %s: %v", poolWithOnlyId.ID, err)
+		for _, pool := range poolsByLoadBalancer[lb.ID] {
+			publishPoolStatus(lb, pool)
+		}
+
+		// the status tree contains the status of all listeners and the members of their pools
+		mc := newOpenStackMetric("loadbalancer_statuses", "get")
+		statuses, err := loadbalancers.GetStatuses(client, lb.ID).Extract()
+		if mc.Observe(err) != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); ok {
+				klog.V(4).Infof("skipping statuses of load balancer %s as it does not exist anymore", lb.ID)
 				continue
 			}
-			publishPoolStatus(lb, *pool)
+			// only warn, the failed request is counted by the openstack metrics
+			klog.Warningf("Unable to get statuses of load balancer %s: %v", lb.ID, err)
+			continue
+		}
+		seenPools := map[string]bool{}
+		if statuses.Loadbalancer != nil {
+			seenPools = publishStatusTree(lb, *statuses.Loadbalancer)
+		}
 
-			// for the pool members
-			for _, memberWithOnlyId := range pool.Members {
-				member, err := pools.GetMember(client, pool.ID, memberWithOnlyId.ID).Extract()
-				if err != nil {
-					klog.Warningf("Unable to get member %s of pool %s: %v", memberWithOnlyId.ID, poolWithOnlyId.ID, err)
+		// pools without listener are not part of the status tree
+		for _, pool := range poolsByLoadBalancer[lb.ID] {
+			if seenPools[pool.ID] || len(pool.Members) == 0 {
+				continue
+			}
+			mc := newOpenStackMetric("loadbalancer_pool_member", "list")
+			pages, err := pools.ListMembers(client, pool.ID, pools.ListMembersOpts{}).AllPages()
+			if mc.Observe(err) != nil {
+				if _, ok := err.(gophercloud.ErrDefault404); ok {
+					klog.V(4).Infof("skipping members of pool %s as it does not exist anymore", pool.ID)
 					continue
 				}
-				publishMemberStatus(lb, *pool, *member)
+				// only warn, the failed request is counted by the openstack metrics
+				klog.Warningf("Unable to list members of pool %s: %v", pool.ID, err)
+				continue
+			}
+			members, err := pools.ExtractMembers(pages)
+			if err != nil {
+				klog.Warningf("Unable to extract members of pool %s: %v", pool.ID, err)
+				continue
+			}
+			for _, member := range members {
+				publishMemberStatus(lb, pool, member)
 			}
 		}
 	}
 
+	// the listeners, l7 policies and health monitors are listed at once
+	var errs []error
+	if err := publishLoadBalancerListenerMetrics(client, resources, loadBalancerList, poolList); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := publishLoadBalancerStatsMetrics(client, resources, loadBalancerList); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors while getting load balancer details, first error: %v", len(errs), errs[0])
+	}
 	return nil
 }
 
+// matchLoadBalancerServices returns the load balancers with the service they
+// belong to and the services without load balancer. A service is matched by
+// the load balancer id annotation or, if it has none, by the load balancer name.
+// The name is only used if no other cluster has a service with the same
+// namespace and name and if the cluster in the name is the cluster of the
+// service. The cluster of a service is unknown with the in-cluster config and
+// without cluster name, then only the namespace and name are compared.
+func matchLoadBalancerServices(lbs []loadbalancers.LoadBalancer, services []clusterService) ([]loadBalancerWithService, []clusterService) {
+	servicesByLoadBalancerID := map[string]*clusterService{}
+	servicesByName := map[string]*clusterService{}
+	ambiguousNames := map[string]bool{}
+	for i, svc := range services {
+		if id := svc.Annotations[loadBalancerIDAnnotation]; id != "" {
+			servicesByLoadBalancerID[id] = &services[i]
+			continue
+		}
+		key := svc.Namespace + "/" + svc.Name
+		if _, ok := servicesByName[key]; ok {
+			klog.V(4).Infof("not matching service %s by load balancer name: it exists in several clusters", key)
+			ambiguousNames[key] = true
+		}
+		servicesByName[key] = &services[i]
+	}
+	for key := range ambiguousNames {
+		delete(servicesByName, key)
+	}
+
+	matched := map[*clusterService]bool{}
+	var loadBalancerList []loadBalancerWithService
+	for _, lb := range lbs {
+		l := loadBalancerWithService{LoadBalancer: lb}
+		cluster, namespace, name, ok := parseLoadBalancerName(lb.Name)
+		if ok {
+			l.cluster = cluster
+		}
+		if svc, found := servicesByLoadBalancerID[lb.ID]; found {
+			l.service = svc
+		} else if svc, found := servicesByName[namespace+"/"+name]; ok && found && (svc.cluster == "" || svc.cluster == cluster) {
+			l.service = svc
+		}
+		if l.service != nil {
+			matched[l.service] = true
+		}
+		loadBalancerList = append(loadBalancerList, l)
+	}
+
+	var servicesWithoutLoadBalancer []clusterService
+	for i, svc := range services {
+		if !matched[&services[i]] {
+			servicesWithoutLoadBalancer = append(servicesWithoutLoadBalancer, svc)
+		}
+	}
+
+	return loadBalancerList, servicesWithoutLoadBalancer
+}
+
 // publishLoadBalancerMetric extracts data from a load balancer and exposes the metrics via prometheus
-func publishLoadBalancerMetric(lb loadbalancers.LoadBalancer) {
-	labels := []string{lb.ID, lb.Name, lb.VipAddress, lb.Provider, lb.VipPortID}
+func publishLoadBalancerMetric(lb loadBalancerWithService) {
+	labels := lb.labelValues()
 
 	loadbalancerAdminStateUp.WithLabelValues(labels...).Set(boolFloat64(lb.AdminStateUp))
+	loadbalancerInfo.WithLabelValues(append(labels, lb.FlavorID, lb.AvailabilityZone)...).Set(1)
 
 	// create one metric per provisioning status
 	for _, state := range provisioningStates {
 		stateLabels := append(labels, state)
 		loadbalancerStatus.WithLabelValues(stateLabels...).Set(boolFloat64(lb.ProvisioningStatus == state))
 	}
+
+	// create one metric per operating status
+	for _, state := range operatingStates {
+		stateLabels := append(labels, state)
+		loadbalancerOperatingStatus.WithLabelValues(stateLabels...).Set(boolFloat64(lb.OperatingStatus == state))
+	}
 }
 
-func publishPoolStatus(lb loadbalancers.LoadBalancer, pool pools.Pool) {
-	labels := []string{lb.ID, lb.Name, lb.VipAddress, lb.Provider, lb.VipPortID, pool.ID, pool.Name}
+// publishStatusTree exposes the operating status of the listeners and the
+// status of the pool members from the status tree of a load balancer.
+// It returns the ids of the pools which are part of the tree.
+func publishStatusTree(lb loadBalancerWithService, tree loadbalancers.LoadBalancer) map[string]bool {
+	labels := lb.labelValues()
+
+	// a pool is part of the tree of every listener using it
+	seenPools := map[string]bool{}
+	for _, listener := range tree.Listeners {
+		listenerLabelValues := append(labels, listener.ID, listener.Name)
+		for _, state := range operatingStates {
+			stateLabels := append(listenerLabelValues, state)
+			loadbalancerListenerOperatingStatus.WithLabelValues(stateLabels...).Set(boolFloat64(listener.OperatingStatus == state))
+		}
+
+		for _, pool := range listener.Pools {
+			if seenPools[pool.ID] {
+				continue
+			}
+			seenPools[pool.ID] = true
+
+			for _, member := range pool.Members {
+				publishMemberStatus(lb, pool, member)
+			}
+		}
+	}
+
+	return seenPools
+}
+
+func publishPoolStatus(lb loadBalancerWithService, pool pools.Pool) {
+	labels := append(lb.labelValues(), pool.ID, pool.Name)
 
 	for _, state := range poolProvisioningStates {
 		stateLabels := append(labels, state)
 		loadbalancerPoolProvisioningStatus.WithLabelValues(stateLabels...).Set(boolFloat64(pool.ProvisioningStatus == state))
 	}
+
+	for _, state := range operatingStates {
+		stateLabels := append(labels, state)
+		loadbalancerPoolOperatingStatus.WithLabelValues(stateLabels...).Set(boolFloat64(pool.OperatingStatus == state))
+	}
 }
 
-func publishMemberStatus(lb loadbalancers.LoadBalancer, pool pools.Pool, member pools.Member) {
-	labels := []string{lb.ID, lb.Name, lb.VipAddress, lb.Provider, lb.VipPortID, pool.ID, pool.Name, member.ID, member.Name}
+func publishMemberStatus(lb loadBalancerWithService, pool pools.Pool, member pools.Member) {
+	labels := append(lb.labelValues(), pool.ID, pool.Name, member.ID, member.Name)
 
 	for _, state := range poolProvisioningStates {
 		stateLabels := append(labels, state)
 		loadbalancerPoolMemberProvisioningStatus.WithLabelValues(stateLabels...).Set(boolFloat64(member.ProvisioningStatus == state))
 	}
+
+	for _, state := range operatingStates {
+		stateLabels := append(labels, state)
+		loadbalancerPoolMemberOperatingStatus.WithLabelValues(stateLabels...).Set(boolFloat64(member.OperatingStatus == state))
+	}
 }
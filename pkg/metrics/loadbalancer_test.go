@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newClusterService(cluster, namespace, name, loadBalancerID string) clusterService {
+	svc := clusterService{
+		Service: corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		},
+		cluster: cluster,
+	}
+	if loadBalancerID != "" {
+		svc.Annotations = map[string]string{loadBalancerIDAnnotation: loadBalancerID}
+	}
+	return svc
+}
+
+func TestMatchLoadBalancerServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		lbs      []loadbalancers.LoadBalancer
+		services []clusterService
+		// matched are the "<cluster>/<namespace>/<name>" of the service by load balancer id
+		matched map[string]string
+		// clusters are the cluster names of cloud-provider-openstack by load balancer id
+		clusters map[string]string
+		// withoutLoadBalancer are the "<cluster>/<namespace>/<name>" of the services without load balancer
+		withoutLoadBalancer []string
+	}{
+		{
+			name:     "match by annotation",
+			lbs:      []loadbalancers.LoadBalancer{{ID: "lb1", Name: "custom"}},
+			services: []clusterService{newClusterService("a", "default", "web", "lb1")},
+			matched:  map[string]string{"lb1": "a/default/web"},
+		},
+		{
+			name:     "annotation wins over name",
+			lbs:      []loadbalancers.LoadBalancer{{ID: "lb1", Name: "kube_service_a_default_web"}, {ID: "lb2", Name: "custom"}},
+			services: []clusterService{newClusterService("a", "default", "web", "lb2")},
+			matched:  map[string]string{"lb2": "a/default/web"},
+			clusters: map[string]string{"lb1": "a"},
+		},
+		{
+			name:     "match by name of the same cluster",
+			lbs:      []loadbalancers.LoadBalancer{{ID: "lb1", Name: "kube_service_a_default_web"}},
+			services: []clusterService{newClusterService("a", "default", "web", "")},
+			matched:  map[string]string{"lb1": "a/default/web"},
+			clusters: map[string]string{"lb1": "a"},
+		},
+		{
+			name:     "match by name with unknown cluster",
+			lbs:      []loadbalancers.LoadBalancer{{ID: "lb1", Name: "kube_service_kubernetes_default_web"}},
+			services: []clusterService{newClusterService("", "default", "web", "")},
+			matched:  map[string]string{"lb1": "/default/web"},
+			clusters: map[string]string{"lb1": "kubernetes"},
+		},
+		{
+			name:                "no match by name of another cluster",
+			lbs:                 []loadbalancers.LoadBalancer{{ID: "lb1", Name: "kube_service_b_default_web"}},
+			services:            []clusterService{newClusterService("a", "default", "web", "")},
+			clusters:            map[string]string{"lb1": "b"},
+			withoutLoadBalancer: []string{"a/default/web"},
+		},
+		{
+			name: "no match by name existing in several clusters",
+			lbs:  []loadbalancers.LoadBalancer{{ID: "lb1", Name: "kube_service_a_default_web"}},
+			services: []clusterService{
+				newClusterService("a", "default", "web", ""),
+				newClusterService("b", "default", "web", ""),
+			},
+			clusters:            map[string]string{"lb1": "a"},
+			withoutLoadBalancer: []string{"a/default/web", "b/default/web"},
+		},
+		{
+			name: "ambiguous name does not affect annotated services",
+			lbs:  []loadbalancers.LoadBalancer{{ID: "lb1", Name: "kube_service_a_default_web"}},
+			services: []clusterService{
+				newClusterService("a", "default", "web", "lb1"),
+				newClusterService("b", "default", "web", ""),
+			},
+			matched:             map[string]string{"lb1": "a/default/web"},
+			clusters:            map[string]string{"lb1": "a"},
+			withoutLoadBalancer: []string{"b/default/web"},
+		},
+		{
+			name:                "service without load balancer",
+			services:            []clusterService{newClusterService("a", "default", "web", "lb1")},
+			withoutLoadBalancer: []string{"a/default/web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadBalancerList, servicesWithoutLoadBalancer := matchLoadBalancerServices(tt.lbs, tt.services)
+
+			if len(loadBalancerList) != len(tt.lbs) {
+				t.Fatalf("got %d load balancers, want %d", len(loadBalancerList), len(tt.lbs))
+			}
+			for _, lb := range loadBalancerList {
+				var matched string
+				if lb.service != nil {
+					matched = serviceKey(*lb.service)
+				}
+				if matched != tt.matched[lb.ID] {
+					t.Errorf("load balancer %s matched service %q, want %q", lb.ID, matched, tt.matched[lb.ID])
+				}
+				if lb.cluster != tt.clusters[lb.ID] {
+					t.Errorf("load balancer %s has cluster %q, want %q", lb.ID, lb.cluster, tt.clusters[lb.ID])
+				}
+			}
+
+			var without []string
+			for _, svc := range servicesWithoutLoadBalancer {
+				without = append(without, serviceKey(svc))
+			}
+			sort.Strings(without)
+			if !equalStrings(without, tt.withoutLoadBalancer) {
+				t.Errorf("got services without load balancer %v, want %v", without, tt.withoutLoadBalancer)
+			}
+		})
+	}
+}
+
+func serviceKey(svc clusterService) string {
+	return svc.cluster + "/" + svc.Namespace + "/" + svc.Name
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,273 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	loadbalancerBytesIn                   *constCounterVec
+	loadbalancerBytesOut                  *constCounterVec
+	loadbalancerConnections               *constCounterVec
+	loadbalancerRequestErrors             *constCounterVec
+	loadbalancerActiveConnections         *prometheus.GaugeVec
+	loadbalancerListenerBytesIn           *constCounterVec
+	loadbalancerListenerBytesOut          *constCounterVec
+	loadbalancerListenerConnections       *constCounterVec
+	loadbalancerListenerRequestErrors     *constCounterVec
+	loadbalancerListenerActiveConnections *prometheus.GaugeVec
+)
+
+// constCounterVec exposes counters whose values are read from another system,
+// like the traffic statistics of octavia. In contrast to prometheus.CounterVec
+// the values are set and not incremented.
+type constCounterVec struct {
+	desc *prometheus.Desc
+
+	mu     sync.Mutex
+	values map[string]constCounterValue
+}
+
+type constCounterValue struct {
+	labelValues []string
+	value       float64
+}
+
+func newConstCounterVec(name, help string, labels []string) *constCounterVec {
+	return &constCounterVec{
+		desc:   prometheus.NewDesc(name, help, labels, nil),
+		values: map[string]constCounterValue{},
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (v *constCounterVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+// Collect implements prometheus.Collector.
+func (v *constCounterVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, c := range v.values {
+		ch <- prometheus.MustNewConstMetric(v.desc, prometheus.CounterValue, c.value, c.labelValues...)
+	}
+}
+
+// Set sets the counter for the given label values.
+func (v *constCounterVec) Set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// copy the label values, callers reuse the slice
+	v.values[strings.Join(labelValues, "\xff")] = constCounterValue{
+		labelValues: append([]string(nil), labelValues...),
+		value:       value,
+	}
+}
+
+// Reset deletes all counters.
+func (v *constCounterVec) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values = map[string]constCounterValue{}
+}
+
+func registerLoadBalancerStatsMetrics() {
+	listenerStatsLabels := append(loadBalancerLabels, listenerLabels...)
+
+	loadbalancerBytesIn = newConstCounterVec(
+		generateName("loadbalancer_bytes_in_total"),
+		"Total bytes received by a load balancer",
+		loadBalancerLabels,
+	)
+	loadbalancerBytesOut = newConstCounterVec(
+		generateName("loadbalancer_bytes_out_total"),
+		"Total bytes sent by a load balancer",
+		loadBalancerLabels,
+	)
+	loadbalancerConnections = newConstCounterVec(
+		generateName("loadbalancer_connections_total"),
+		"Total connections handled by a load balancer",
+		loadBalancerLabels,
+	)
+	loadbalancerRequestErrors = newConstCounterVec(
+		generateName("loadbalancer_request_errors_total"),
+		"Total requests a load balancer was unable to fulfill",
+		loadBalancerLabels,
+	)
+	loadbalancerActiveConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_active_connections"),
+			Help: "Currently active connections of a load balancer",
+		},
+		loadBalancerLabels,
+	)
+	loadbalancerListenerBytesIn = newConstCounterVec(
+		generateName("loadbalancer_listener_bytes_in_total"),
+		"Total bytes received by a load balancer listener",
+		listenerStatsLabels,
+	)
+	loadbalancerListenerBytesOut = newConstCounterVec(
+		generateName("loadbalancer_listener_bytes_out_total"),
+		"Total bytes sent by a load balancer listener",
+		listenerStatsLabels,
+	)
+	loadbalancerListenerConnections = newConstCounterVec(
+		generateName("loadbalancer_listener_connections_total"),
+		"Total connections handled by a load balancer listener",
+		listenerStatsLabels,
+	)
+	loadbalancerListenerRequestErrors = newConstCounterVec(
+		generateName("loadbalancer_listener_request_errors_total"),
+		"Total requests a load balancer listener was unable to fulfill",
+		listenerStatsLabels,
+	)
+	loadbalancerListenerActiveConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_listener_active_connections"),
+			Help: "Currently active connections of a load balancer listener",
+		},
+		listenerStatsLabels,
+	)
+
+	prometheus.MustRegister(loadbalancerBytesIn)
+	prometheus.MustRegister(loadbalancerBytesOut)
+	prometheus.MustRegister(loadbalancerConnections)
+	prometheus.MustRegister(loadbalancerRequestErrors)
+	prometheus.MustRegister(loadbalancerActiveConnections)
+	prometheus.MustRegister(loadbalancerListenerBytesIn)
+	prometheus.MustRegister(loadbalancerListenerBytesOut)
+	prometheus.MustRegister(loadbalancerListenerConnections)
+	prometheus.MustRegister(loadbalancerListenerRequestErrors)
+	prometheus.MustRegister(loadbalancerListenerActiveConnections)
+}
+
+// publishLoadBalancerStatsMetrics gets the traffic statistics of every load
+// balancer and its listeners and exposes them via prometheus. The statistics
+// api may be forbidden by the octavia policy, the metrics are skipped then.
+// A load balancer or listener deleted after it was listed is skipped.
+func publishLoadBalancerStatsMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, loadBalancerList []loadBalancerWithService) error {
+	// first step: gather the data
+	listenerList, err := resources.Listeners()
+	if err != nil {
+		return err
+	}
+	listenersByLoadBalancer := map[string][]listeners.Listener{}
+	for _, listener := range listenerList {
+		for _, lb := range listener.Loadbalancers {
+			listenersByLoadBalancer[lb.ID] = append(listenersByLoadBalancer[lb.ID], listener)
+		}
+	}
+
+	var errs []error
+	loadBalancerStats := map[string]loadbalancers.Stats{}
+	listenerStats := map[string]listeners.Stats{}
+	for _, lb := range loadBalancerList {
+		mc := newOpenStackMetric("loadbalancer_stats", "get")
+		stats, err := loadbalancers.GetStats(client, lb.ID).Extract()
+		if mc.Observe(err) != nil {
+			if _, ok := err.(gophercloud.ErrDefault403); ok {
+				// reset metrics if the api is forbidden to not publish them anymore
+				resetLoadBalancerStatsMetrics()
+				klog.V(4).Info("skipping load balancer stats metrics as the api is forbidden")
+				return statsErrors(errs)
+			}
+			if _, ok := err.(gophercloud.ErrDefault404); ok {
+				klog.V(4).Infof("skipping stats of load balancer %s as it does not exist anymore", lb.ID)
+				continue
+			}
+			klog.Warningf("Unable to get stats of load balancer %s: %v", lb.ID, err)
+			errs = append(errs, err)
+			continue
+		}
+		loadBalancerStats[lb.ID] = *stats
+
+		for _, listener := range listenersByLoadBalancer[lb.ID] {
+			mc := newOpenStackMetric("loadbalancer_listener_stats", "get")
+			stats, err := listeners.GetStats(client, listener.ID).Extract()
+			if mc.Observe(err) != nil {
+				if _, ok := err.(gophercloud.ErrDefault404); ok {
+					klog.V(4).Infof("skipping stats of listener %s as it does not exist anymore", listener.ID)
+					continue
+				}
+				klog.Warningf("Unable to get stats of listener %s: %v", listener.ID, err)
+				errs = append(errs, err)
+				continue
+			}
+			listenerStats[listener.ID] = *stats
+		}
+	}
+
+	// second step: reset the old metrics
+	resetLoadBalancerStatsMetrics()
+
+	// third step: publish the metrics
+	for _, lb := range loadBalancerList {
+		stats, ok := loadBalancerStats[lb.ID]
+		if !ok {
+			continue
+		}
+		publishLoadBalancerStats(lb, stats)
+
+		for _, listener := range listenersByLoadBalancer[lb.ID] {
+			if stats, ok := listenerStats[listener.ID]; ok {
+				publishListenerStats(lb, listener, stats)
+			}
+		}
+	}
+
+	return statsErrors(errs)
+}
+
+// statsErrors aggregates the errors while getting the stats
+func statsErrors(errs []error) error {
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors while getting load balancer stats, first error: %v", len(errs), errs[0])
+	}
+	return nil
+}
+
+// resetLoadBalancerStatsMetrics resets the load balancer stats metrics
+func resetLoadBalancerStatsMetrics() {
+	loadbalancerBytesIn.Reset()
+	loadbalancerBytesOut.Reset()
+	loadbalancerConnections.Reset()
+	loadbalancerRequestErrors.Reset()
+	loadbalancerActiveConnections.Reset()
+	loadbalancerListenerBytesIn.Reset()
+	loadbalancerListenerBytesOut.Reset()
+	loadbalancerListenerConnections.Reset()
+	loadbalancerListenerRequestErrors.Reset()
+	loadbalancerListenerActiveConnections.Reset()
+}
+
+// publishLoadBalancerStats exposes the traffic statistics of a load balancer via prometheus
+func publishLoadBalancerStats(lb loadBalancerWithService, stats loadbalancers.Stats) {
+	labels := lb.labelValues()
+
+	loadbalancerBytesIn.Set(float64(stats.BytesIn), labels...)
+	loadbalancerBytesOut.Set(float64(stats.BytesOut), labels...)
+	loadbalancerConnections.Set(float64(stats.TotalConnections), labels...)
+	loadbalancerRequestErrors.Set(float64(stats.RequestErrors), labels...)
+	loadbalancerActiveConnections.WithLabelValues(labels...).Set(float64(stats.ActiveConnections))
+}
+
+// publishListenerStats exposes the traffic statistics of a load balancer listener via prometheus
+func publishListenerStats(lb loadBalancerWithService, listener listeners.Listener, stats listeners.Stats) {
+	labels := append(lb.labelValues(), listener.ID, listener.Name)
+
+	loadbalancerListenerBytesIn.Set(float64(stats.BytesIn), labels...)
+	loadbalancerListenerBytesOut.Set(float64(stats.BytesOut), labels...)
+	loadbalancerListenerConnections.Set(float64(stats.TotalConnections), labels...)
+	loadbalancerListenerRequestErrors.Set(float64(stats.RequestErrors), labels...)
+	loadbalancerListenerActiveConnections.WithLabelValues(labels...).Set(float64(stats.ActiveConnections))
+}
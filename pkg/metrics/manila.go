@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// microversion of the manila api needed for the share type names, the
+	// export locations and the quota usage
+	manilaMicroversion = "2.25"
+)
+
+var (
+	// labels of the share metrics, followed by the same kubernetes labels as the cinder volumes
+	shareLabels = []string{"id", "name", "description", "status", "availability_zone", "share_type", "share_proto", "pvc_name", "pvc_namespace", "pv_name", "pv_storage_class", "pv_reclaim_policy", "pv_fs_type", "k8s_cluster", "owner_cluster"}
+
+	// possible manila states, from https://docs.openstack.org/api-ref/shared-file-system/#shares
+	manilaStates = []string{"creating", "creating_from_snapshot", "deleting", "deleted", "error", "error_deleting", "available", "inactive", "manage_starting", "manage_error", "unmanage_starting", "unmanage_error", "unmanaged", "extending", "extending_error", "shrinking", "shrinking_error", "shrinking_possible_data_loss_error", "migrating", "migrating_to", "replication_change", "reverting", "reverting_error", "awaiting_transfer"}
+
+	manilaQuotaShares         *prometheus.GaugeVec
+	manilaQuotaShareGigabytes *prometheus.GaugeVec
+	manilaShareCreatedAt      *prometheus.GaugeVec
+	manilaShareStatus         *prometheus.GaugeVec
+	manilaShareSize           *prometheus.GaugeVec
+	manilaShareExportLocation *prometheus.GaugeVec
+)
+
+// manilaQuotaUsage is the usage of a manila quota, gophercloud does not
+// implement the quota api of manila.
+type manilaQuotaUsage struct {
+	InUse    int `json:"in_use"`
+	Reserved int `json:"reserved"`
+	Limit    int `json:"limit"`
+}
+
+func registerManilaMetrics() {
+	manilaQuotaShares = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("manila_quota_shares"),
+			Help: "Manila share metric (number of shares)",
+		},
+		[]string{"quota_type"},
+	)
+	manilaQuotaShareGigabytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("manila_quota_share_gigabytes"),
+			Help: "Manila share metric (GB)",
+		},
+		[]string{"quota_type"},
+	)
+	manilaShareCreatedAt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("manila_share_created_at"),
+			Help: "Manila share created at",
+		},
+		shareLabels,
+	)
+	manilaShareStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("manila_share_status"),
+			Help: "Manila share status",
+		},
+		shareLabels,
+	)
+	manilaShareSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("manila_share_size_gigabytes"),
+			Help: "Manila share size in GiB",
+		},
+		shareLabels,
+	)
+	manilaShareExportLocation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("manila_share_export_location"),
+			Help: "Manila share export location",
+		},
+		append(shareLabels, "path", "preferred"),
+	)
+
+	prometheus.MustRegister(manilaQuotaShares)
+	prometheus.MustRegister(manilaQuotaShareGigabytes)
+	prometheus.MustRegister(manilaShareCreatedAt)
+	prometheus.MustRegister(manilaShareStatus)
+	prometheus.MustRegister(manilaShareSize)
+	prometheus.MustRegister(manilaShareExportLocation)
+}
+
+// PublishManilaMetrics makes the list request to the shared file system api
+// and passes the result to a publish function. The shares are matched with
+// the pvs of the manila csi drivers.
+func PublishManilaMetrics(client *gophercloud.ServiceClient, clusters []*KubernetesCache, tenantID string) error {
+	// the export locations and the quota usage need a newer microversion
+	c := *client
+	c.Microversion = manilaMicroversion
+	client = &c
+
+	// first step: gather the data
+
+	// get the manila pvs to add metadata
+	pvs, err := getPVsByManilaID(clusters)
+	if err != nil {
+		return err
+	}
+
+	mc := newOpenStackMetric("share", "list")
+	pages, err := shares.ListDetail(client, shares.ListOpts{}).AllPages()
+	if mc.Observe(err) != nil {
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list shares: %v", err)
+		return err
+	}
+	sharesList, err := shares.ExtractShares(pages)
+	if err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract shares: %v", err)
+		return err
+	}
+	sharesList = filterShares(sharesList)
+
+	// listing the export locations is one request per share, so it is limited
+	// to the available shares, the other shares have no usable export location.
+	// A share may be deleted after it was listed and a failed request does not
+	// fail the scrape.
+	exportLocations := map[string][]shares.ExportLocation{}
+	for _, share := range sharesList {
+		if share.Status != "available" {
+			continue
+		}
+		mc := newOpenStackMetric("share_export_location", "list")
+		locations, err := shares.ListExportLocations(client, share.ID).Extract()
+		if mc.Observe(err) != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); ok {
+				klog.V(4).Infof("skipping export locations of share %s as it does not exist anymore", share.ID)
+				continue
+			}
+			// only warn, the failed request is counted by the openstack metrics
+			klog.Warningf("Unable to list export locations of share %s: %v", share.ID, err)
+			continue
+		}
+		exportLocations[share.ID] = locations
+	}
+
+	mc = newOpenStackMetric("share_quotasets_usage", "get")
+	var quotas struct {
+		QuotaSet struct {
+			Shares    manilaQuotaUsage `json:"shares"`
+			Gigabytes manilaQuotaUsage `json:"gigabytes"`
+		} `json:"quota_set"`
+	}
+	_, err = client.Get(client.ServiceURL("quota-sets", tenantID, "detail"), &quotas, nil)
+	if mc.Observe(err) != nil {
+		// only warn, maybe the next get will work.
+		klog.Warningf("Unable to get share quotas: %v", err)
+		return err
+	}
+
+	// second step: reset the old metrics
+	// manilaQuotaShares and manilaQuotaShareGigabytes are not dynamic and do not need to be reset
+	manilaShareCreatedAt.Reset()
+	manilaShareStatus.Reset()
+	manilaShareSize.Reset()
+	manilaShareExportLocation.Reset()
+
+	// third step: publish the metrics
+	for _, share := range sharesList {
+		if pv, ok := pvs[share.ID]; ok {
+			publishShareMetrics(share, exportLocations[share.ID], &pv)
+		} else {
+			publishShareMetrics(share, exportLocations[share.ID], nil)
+		}
+	}
+
+	publishManilaQuota(manilaQuotaShares, quotas.QuotaSet.Shares)
+	publishManilaQuota(manilaQuotaShareGigabytes, quotas.QuotaSet.Gigabytes)
+
+	return nil
+}
+
+// publishManilaQuota publishes the usage of a manila quota
+func publishManilaQuota(metric *prometheus.GaugeVec, q manilaQuotaUsage) {
+	metric.WithLabelValues("in-use").Set(float64(q.InUse))
+	metric.WithLabelValues("reserved").Set(float64(q.Reserved))
+	metric.WithLabelValues("limit").Set(float64(q.Limit))
+}
+
+// publishShareMetrics extracts data from a share and exposes the metrics via prometheus
+func publishShareMetrics(share shares.Share, locations []shares.ExportLocation, pv *clusterPV) {
+	k8sMetadata := extractK8sMetadata(pv)
+
+	labels := []string{share.ID, share.Name, share.Description, share.Status, share.AvailabilityZone, share.ShareTypeName, share.ShareProto}
+	labels = append(labels, k8sMetadata...)
+	labels = append(labels, shareCluster(share))
+
+	manilaShareCreatedAt.WithLabelValues(labels...).Set(float64(share.CreatedAt.Unix()))
+	manilaShareSize.WithLabelValues(labels...).Set(float64(share.Size))
+
+	for _, location := range locations {
+		l := append(labels, location.Path, strconv.FormatBool(location.Preferred))
+		manilaShareExportLocation.WithLabelValues(l...).Set(1)
+	}
+
+	// create one metric per state. If it's the current state it's 1
+	for _, status := range manilaStates {
+		labels := []string{share.ID, share.Name, share.Description, status, share.AvailabilityZone, share.ShareTypeName, share.ShareProto}
+		labels = append(labels, k8sMetadata...)
+		labels = append(labels, shareCluster(share))
+		manilaShareStatus.WithLabelValues(labels...).Set(boolFloat64(share.Status == status))
+	}
+}
@@ -19,12 +19,23 @@ var (
 func RegisterMetrics(prefix string) {
 	metricsPrefix = prefix
 	registerCinderMetrics()
+	registerManilaMetrics()
 	registerNeutronMetrics()
+	registerPortMetrics()
+	registerNetworkMetrics()
+	registerRouterMetrics()
+	registerSecurityGroupMetrics()
 	registerLoadBalancerMetrics()
+	registerLoadBalancerListenerMetrics()
+	registerLoadBalancerStatsMetrics()
+	registerAmphoraMetrics()
+	registerOctaviaMetrics()
 	registerOpenStackMetrics()
 	registerFWaaSV1Metrics()
 	registerFWaaSV2Metrics()
 	registerServerMetrics()
+	registerServerGroupMetrics()
+	registerVolumeAttachmentMetrics()
 }
 
 // AddPrefix adds the given prefix to the string, if set
@@ -38,3 +49,13 @@ func AddPrefix(name, prefix string) string {
 func generateName(name string) string {
 	return AddPrefix(name, metricsPrefix)
 }
+
+// contains returns true if the list contains the string
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
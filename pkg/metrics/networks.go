@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/external"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/networkipavailabilities"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	neutronNetworkIPsTotal *prometheus.GaugeVec
+	neutronNetworkIPsUsed  *prometheus.GaugeVec
+	neutronSubnetIPsTotal  *prometheus.GaugeVec
+	neutronSubnetIPsUsed   *prometheus.GaugeVec
+
+	networkLabels = []string{"network_id", "network_name", "router_external"}
+	subnetLabels  = []string{"subnet_id", "subnet_name", "cidr", "ip_version"}
+)
+
+// networkWithExt is a network including the router:external attribute
+type networkWithExt struct {
+	networks.Network
+	external.NetworkExternalExt
+}
+
+func registerNetworkMetrics() {
+	neutronNetworkIPsTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_network_ips_total"),
+			Help: "Total number of ips of a neutron network",
+		},
+		networkLabels,
+	)
+	neutronNetworkIPsUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_network_ips_used"),
+			Help: "Number of used ips of a neutron network",
+		},
+		networkLabels,
+	)
+	neutronSubnetIPsTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_subnet_ips_total"),
+			Help: "Total number of ips of a neutron subnet",
+		},
+		append(networkLabels, subnetLabels...),
+	)
+	neutronSubnetIPsUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_subnet_ips_used"),
+			Help: "Number of used ips of a neutron subnet",
+		},
+		append(networkLabels, subnetLabels...),
+	)
+
+	prometheus.MustRegister(neutronNetworkIPsTotal)
+	prometheus.MustRegister(neutronNetworkIPsUsed)
+	prometheus.MustRegister(neutronSubnetIPsTotal)
+	prometheus.MustRegister(neutronSubnetIPsUsed)
+}
+
+// PublishNetworkMetrics makes the list request to the network ip availability
+// api and passes the result to a publish function. The api is restricted to
+// admins by the default neutron policy, so a forbidden response only skips
+// the metrics. The availabilities are limited to the networks of the project
+// and the external networks, which are shared by all projects.
+func PublishNetworkMetrics(client *gophercloud.ServiceClient, tenantID string) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("network_ip_availability", "list")
+	pages, err := networkipavailabilities.List(client, networkipavailabilities.ListOpts{ProjectID: tenantID}).AllPages()
+	if mc.Observe(err) != nil {
+		if _, ok := err.(gophercloud.ErrDefault403); ok {
+			// reset metrics if the api is forbidden to not publish them anymore
+			resetNetworkMetrics()
+			klog.Info("skipping network ip availability metrics as the api is forbidden")
+			return nil
+		}
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list network ip availabilities: %v", err)
+		return err
+	}
+	availabilities, err := networkipavailabilities.ExtractNetworkIPAvailabilities(pages)
+	if err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract network ip availabilities: %v", err)
+		return err
+	}
+
+	routerExternal := true
+	mc = newOpenStackMetric("network", "list")
+	pages, err = networks.List(client, external.ListOptsExt{ListOptsBuilder: networks.ListOpts{}, External: &routerExternal}).AllPages()
+	if mc.Observe(err) != nil {
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list external networks: %v", err)
+		return err
+	}
+	var networkList []networkWithExt
+	if err := networks.ExtractNetworksInto(pages, &networkList); err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract external networks: %v", err)
+		return err
+	}
+
+	// the external networks belong to other projects, so their availabilities
+	// are not part of the list
+	externalNetworks := map[string]bool{}
+	listed := map[string]bool{}
+	for _, availability := range availabilities {
+		listed[availability.NetworkID] = true
+	}
+	for _, network := range networkList {
+		externalNetworks[network.ID] = network.External
+		if listed[network.ID] {
+			continue
+		}
+		mc := newOpenStackMetric("network_ip_availability", "get")
+		availability, err := networkipavailabilities.Get(client, network.ID).Extract()
+		if mc.Observe(err) != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); ok {
+				klog.V(4).Infof("skipping ip availability of network %s as it does not exist anymore", network.ID)
+				continue
+			}
+			// only warn, the failed request is counted by the openstack metrics
+			klog.Warningf("Unable to get ip availability of network %s: %v", network.ID, err)
+			continue
+		}
+		availabilities = append(availabilities, *availability)
+	}
+
+	// second step: reset the old metrics
+	resetNetworkMetrics()
+
+	// third step: publish the metrics
+	for _, availability := range availabilities {
+		publishNetworkIPAvailabilityMetric(availability, externalNetworks[availability.NetworkID])
+	}
+
+	return nil
+}
+
+// resetNetworkMetrics resets the network metrics
+func resetNetworkMetrics() {
+	neutronNetworkIPsTotal.Reset()
+	neutronNetworkIPsUsed.Reset()
+	neutronSubnetIPsTotal.Reset()
+	neutronSubnetIPsUsed.Reset()
+}
+
+// publishNetworkIPAvailabilityMetric extracts data from a network ip availability and exposes the metrics via prometheus
+func publishNetworkIPAvailabilityMetric(availability networkipavailabilities.NetworkIPAvailability, routerExternal bool) {
+	labels := []string{availability.NetworkID, availability.NetworkName, strconv.FormatBool(routerExternal)}
+
+	neutronNetworkIPsTotal.WithLabelValues(labels...).Set(parseIPCount(availability.TotalIPs))
+	neutronNetworkIPsUsed.WithLabelValues(labels...).Set(parseIPCount(availability.UsedIPs))
+
+	for _, subnet := range availability.SubnetIPAvailabilities {
+		l := append(labels, subnet.SubnetID, subnet.SubnetName, subnet.CIDR, strconv.Itoa(subnet.IPVersion))
+		neutronSubnetIPsTotal.WithLabelValues(l...).Set(parseIPCount(subnet.TotalIPs))
+		neutronSubnetIPsUsed.WithLabelValues(l...).Set(parseIPCount(subnet.UsedIPs))
+	}
+}
+
+// parseIPCount parses the ip count, which may exceed an int64 for ipv6 subnets
+func parseIPCount(count string) float64 {
+	f, err := strconv.ParseFloat(count, 64)
+	if err != nil {
+		klog.Warningf("Unable to parse ip count %q: %v", count, err)
+		return 0
+	}
+	return f
+}
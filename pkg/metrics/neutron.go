@@ -3,23 +3,52 @@
 package metrics
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/quotas"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/klog/v2"
 )
 
 var (
-	neutronFloatingIPStatus    *prometheus.GaugeVec
-	neutronFloatingIPCreated   *prometheus.GaugeVec
-	neutronFloatingIPUpdatedAt *prometheus.GaugeVec
+	neutronFloatingIPStatus        *prometheus.GaugeVec
+	neutronFloatingIPCreated       *prometheus.GaugeVec
+	neutronFloatingIPUpdatedAt     *prometheus.GaugeVec
+	neutronFloatingIPUnassociated  *prometheus.GaugeVec
+	neutronQuotaPorts              *prometheus.GaugeVec
+	neutronQuotaNetworks           *prometheus.GaugeVec
+	neutronQuotaSubnets            *prometheus.GaugeVec
+	neutronQuotaRouters            *prometheus.GaugeVec
+	neutronQuotaFloatingIPs        *prometheus.GaugeVec
+	neutronQuotaSecurityGroups     *prometheus.GaugeVec
+	neutronQuotaSecurityGroupRules *prometheus.GaugeVec
+	neutronQuotaRBACPolicies       *prometheus.GaugeVec
 
 	// Status from https://docs.openstack.org/api-ref/network/v2/index.html?expanded=show-floating-ip-details-detail#show-floating-ip-details
 	floatingIpStatus = []string{"ACTIVE", "DOWN", "ERROR"}
 
-	floatingIPLabels = []string{"id", "floating_ip", "fixed_ip", "port_id"}
+	floatingIPLabels = []string{"id", "floating_ip", "fixed_ip", "port_id", "router_id", "floating_network_id", "description", "tags", "device_type", "device_id", "node", "service_name", "service_namespace", "k8s_cluster", "owner_cluster"}
 )
 
+// floatingIPDevice is the device of the port a floating ip is associated to
+type floatingIPDevice struct {
+	// deviceType is one of "server", "loadbalancer", "router" or empty for other devices
+	deviceType string
+	deviceID   string
+	// node is the kubernetes node of a server
+	node string
+	// service is the kubernetes service of a load balancer
+	service *clusterService
+	// cluster is the kubernetes cluster of the node or service
+	cluster string
+	// ownerCluster is the cluster owning the server or load balancer
+	ownerCluster string
+}
+
 func registerNeutronMetrics() {
 	neutronFloatingIPStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -43,15 +72,100 @@ func registerNeutronMetrics() {
 		floatingIPLabels,
 	)
 
+	neutronFloatingIPUnassociated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_floating_ip_unassociated"),
+			Help: "Neutron floating ip which is not associated to a port",
+		},
+		[]string{"id", "floating_ip", "floating_network_id", "description", "tags"},
+	)
+	neutronQuotaPorts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_ports"),
+			Help: "Number of ports allowed",
+		},
+		[]string{"quota_type"},
+	)
+	neutronQuotaNetworks = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_networks"),
+			Help: "Number of networks allowed",
+		},
+		[]string{"quota_type"},
+	)
+	neutronQuotaSubnets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_subnets"),
+			Help: "Number of subnets allowed",
+		},
+		[]string{"quota_type"},
+	)
+	neutronQuotaRouters = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_routers"),
+			Help: "Number of routers allowed",
+		},
+		[]string{"quota_type"},
+	)
+	neutronQuotaFloatingIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_floating_ips"),
+			Help: "Number of floating ips allowed",
+		},
+		[]string{"quota_type"},
+	)
+	neutronQuotaSecurityGroups = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_security_groups"),
+			Help: "Number of security groups allowed",
+		},
+		[]string{"quota_type"},
+	)
+	neutronQuotaSecurityGroupRules = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_security_group_rules"),
+			Help: "Number of security group rules allowed",
+		},
+		[]string{"quota_type"},
+	)
+	neutronQuotaRBACPolicies = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_quota_rbac_policies"),
+			Help: "Number of rbac policies allowed",
+		},
+		[]string{"quota_type"},
+	)
+
 	prometheus.MustRegister(neutronFloatingIPStatus)
 	prometheus.MustRegister(neutronFloatingIPCreated)
 	prometheus.MustRegister(neutronFloatingIPUpdatedAt)
+	prometheus.MustRegister(neutronFloatingIPUnassociated)
+	prometheus.MustRegister(neutronQuotaPorts)
+	prometheus.MustRegister(neutronQuotaNetworks)
+	prometheus.MustRegister(neutronQuotaSubnets)
+	prometheus.MustRegister(neutronQuotaRouters)
+	prometheus.MustRegister(neutronQuotaFloatingIPs)
+	prometheus.MustRegister(neutronQuotaSecurityGroups)
+	prometheus.MustRegister(neutronQuotaSecurityGroupRules)
+	prometheus.MustRegister(neutronQuotaRBACPolicies)
 }
 
 // PublishNeutronMetrics makes the list request to the neutron api and passes
-// the result to a publish function.
-func PublishNeutronMetrics(neutronClient *gophercloud.ServiceClient, tenantID string) error {
+// the result to a publish function. The ports of the floating ips are resolved
+// to their server, load balancer or router and the kubernetes node or service.
+func PublishNeutronMetrics(neutronClient *gophercloud.ServiceClient, resources *OpenStackResources, clusters []*KubernetesCache, tenantID string) error {
 	// first step: gather the data
+
+	// get the nodes and services to add metadata
+	nodes, err := getNodesByServerID(clusters)
+	if err != nil {
+		return err
+	}
+	services, err := getLoadBalancerServices(clusters)
+	if err != nil {
+		return err
+	}
+
 	mc := newOpenStackMetric("floating_ip", "list")
 	pages, err := floatingips.List(neutronClient, floatingips.ListOpts{}).AllPages()
 	if mc.Observe(err) != nil {
@@ -66,21 +180,143 @@ func PublishNeutronMetrics(neutronClient *gophercloud.ServiceClient, tenantID st
 		return err
 	}
 
+	// get the ports, servers and load balancers to resolve the devices of the floating ips
+	portList, err := resources.Ports()
+	if err != nil {
+		return err
+	}
+	portsByID := map[string]ports.Port{}
+	for _, port := range portList {
+		portsByID[port.ID] = port
+	}
+
+	lbs, err := resources.LoadBalancers()
+	if err != nil {
+		return err
+	}
+	loadBalancerList, _ := matchLoadBalancerServices(lbs, services)
+	loadBalancersByID := map[string]loadBalancerWithService{}
+	for _, lb := range loadBalancerList {
+		loadBalancersByID[lb.ID] = lb
+	}
+
+	serversList, err := resources.Servers()
+	if err != nil {
+		return err
+	}
+	devices := deviceClusters(serversList, lbs)
+	floatingIPList = filterFloatingIPs(floatingIPList, portsByID, devices)
+
 	// second step: reset the old metrics
 	neutronFloatingIPStatus.Reset()
+	neutronFloatingIPCreated.Reset()
 	neutronFloatingIPUpdatedAt.Reset()
+	neutronFloatingIPUnassociated.Reset()
 
 	// third step: publish the metrics
 	for _, fip := range floatingIPList {
-		publishFloatingIPMetric(fip)
+		var device floatingIPDevice
+		if port, ok := portsByID[fip.PortID]; ok {
+			device = resolveFloatingIPDevice(port, nodes, loadBalancersByID)
+			device.ownerCluster = devices[portDeviceID(port)]
+		}
+		publishFloatingIPMetric(fip, device)
+	}
+
+	return nil
+}
+
+// PublishNeutronQuotaMetrics makes the request for the networking quotas and
+// their usage and passes the result to a publish function. The quota details
+// need the quota-details extension and may be forbidden by the neutron policy,
+// a not found or forbidden response only skips the metrics.
+func PublishNeutronQuotaMetrics(client *gophercloud.ServiceClient, tenantID string) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("network_quotas_detail", "get")
+	quotaDetails, err := quotas.GetDetail(client, tenantID).Extract()
+	if mc.Observe(err) != nil {
+		switch err.(type) {
+		case gophercloud.ErrDefault403, gophercloud.ErrDefault404:
+			// reset metrics if the api is not usable to not publish them anymore
+			resetNeutronQuotaMetrics()
+			klog.V(4).Infof("skipping network quota metrics as the api is not usable: %v", err)
+			return nil
+		}
+		// only warn, maybe the next get will work.
+		klog.Warningf("Unable to get network quotas: %v", err)
+		return err
 	}
 
+	// second step: reset the old metrics
+	// the neutron quotas are not dynamic and do not need to be reset
+
+	// third step: publish the metrics
+	publishNeutronQuotas(*quotaDetails)
+
 	return nil
 }
 
+// resetNeutronQuotaMetrics resets the neutron quota metrics
+func resetNeutronQuotaMetrics() {
+	neutronQuotaPorts.Reset()
+	neutronQuotaNetworks.Reset()
+	neutronQuotaSubnets.Reset()
+	neutronQuotaRouters.Reset()
+	neutronQuotaFloatingIPs.Reset()
+	neutronQuotaSecurityGroups.Reset()
+	neutronQuotaSecurityGroupRules.Reset()
+	neutronQuotaRBACPolicies.Reset()
+}
+
+// publishNeutronQuotas publishes all neutron related quotas
+func publishNeutronQuotas(q quotas.QuotaDetailSet) {
+	neutronQuotaPorts.WithLabelValues("in-use").Set(float64(q.Port.Used))
+	neutronQuotaPorts.WithLabelValues("reserved").Set(float64(q.Port.Reserved))
+	neutronQuotaPorts.WithLabelValues("limit").Set(float64(q.Port.Limit))
+
+	neutronQuotaNetworks.WithLabelValues("in-use").Set(float64(q.Network.Used))
+	neutronQuotaNetworks.WithLabelValues("reserved").Set(float64(q.Network.Reserved))
+	neutronQuotaNetworks.WithLabelValues("limit").Set(float64(q.Network.Limit))
+
+	neutronQuotaSubnets.WithLabelValues("in-use").Set(float64(q.Subnet.Used))
+	neutronQuotaSubnets.WithLabelValues("reserved").Set(float64(q.Subnet.Reserved))
+	neutronQuotaSubnets.WithLabelValues("limit").Set(float64(q.Subnet.Limit))
+
+	neutronQuotaRouters.WithLabelValues("in-use").Set(float64(q.Router.Used))
+	neutronQuotaRouters.WithLabelValues("reserved").Set(float64(q.Router.Reserved))
+	neutronQuotaRouters.WithLabelValues("limit").Set(float64(q.Router.Limit))
+
+	neutronQuotaFloatingIPs.WithLabelValues("in-use").Set(float64(q.FloatingIP.Used))
+	neutronQuotaFloatingIPs.WithLabelValues("reserved").Set(float64(q.FloatingIP.Reserved))
+	neutronQuotaFloatingIPs.WithLabelValues("limit").Set(float64(q.FloatingIP.Limit))
+
+	neutronQuotaSecurityGroups.WithLabelValues("in-use").Set(float64(q.SecurityGroup.Used))
+	neutronQuotaSecurityGroups.WithLabelValues("reserved").Set(float64(q.SecurityGroup.Reserved))
+	neutronQuotaSecurityGroups.WithLabelValues("limit").Set(float64(q.SecurityGroup.Limit))
+
+	neutronQuotaSecurityGroupRules.WithLabelValues("in-use").Set(float64(q.SecurityGroupRule.Used))
+	neutronQuotaSecurityGroupRules.WithLabelValues("reserved").Set(float64(q.SecurityGroupRule.Reserved))
+	neutronQuotaSecurityGroupRules.WithLabelValues("limit").Set(float64(q.SecurityGroupRule.Limit))
+
+	neutronQuotaRBACPolicies.WithLabelValues("in-use").Set(float64(q.RBACPolicy.Used))
+	neutronQuotaRBACPolicies.WithLabelValues("reserved").Set(float64(q.RBACPolicy.Reserved))
+	neutronQuotaRBACPolicies.WithLabelValues("limit").Set(float64(q.RBACPolicy.Limit))
+}
+
 // publishFloatingIPMetric extracts data from a floating ip and exposes the metrics via prometheus
-func publishFloatingIPMetric(fip floatingips.FloatingIP) {
-	labels := []string{fip.ID, fip.FloatingIP, fip.FixedIP, fip.PortID}
+func publishFloatingIPMetric(fip floatingips.FloatingIP, device floatingIPDevice) {
+	tags := append([]string(nil), fip.Tags...)
+	sort.Strings(tags)
+
+	labels := []string{fip.ID, fip.FloatingIP, fip.FixedIP, fip.PortID, fip.RouterID, fip.FloatingNetworkID, fip.Description, strings.Join(tags, ",")}
+	labels = append(labels, device.deviceType, device.deviceID, device.node)
+	labels = append(labels, extractServiceMetadata(device.service)...)
+	labels = append(labels, device.cluster, device.ownerCluster)
+
+	if fip.PortID == "" {
+		unassociatedLabels := []string{fip.ID, fip.FloatingIP, fip.FloatingNetworkID, fip.Description, strings.Join(tags, ",")}
+		neutronFloatingIPUnassociated.WithLabelValues(unassociatedLabels...).Set(1)
+	}
 
 	neutronFloatingIPCreated.WithLabelValues(labels...).Set(float64(fip.CreatedAt.Unix()))
 	neutronFloatingIPUpdatedAt.WithLabelValues(labels...).Set(float64(fip.UpdatedAt.Unix()))
@@ -89,5 +325,29 @@ func publishFloatingIPMetric(fip floatingips.FloatingIP) {
 		statusLabels := append(labels, status)
 		neutronFloatingIPStatus.WithLabelValues(statusLabels...).Set(boolFloat64(fip.Status == status))
 	}
+}
 
+// resolveFloatingIPDevice returns the device of the port a floating ip is associated to
+func resolveFloatingIPDevice(port ports.Port, nodes map[string]clusterNode, loadBalancers map[string]loadBalancerWithService) floatingIPDevice {
+	switch {
+	case strings.HasPrefix(port.DeviceOwner, "compute:"):
+		device := floatingIPDevice{deviceType: "server", deviceID: port.DeviceID}
+		if node, ok := nodes[port.DeviceID]; ok {
+			device.node = node.GetName()
+			device.cluster = node.cluster
+		}
+		return device
+	case port.DeviceOwner == "Octavia" || port.DeviceOwner == "neutron:LOADBALANCERV2":
+		// octavia uses the load balancer id prefixed by "lb-" as device id of the vip port
+		device := floatingIPDevice{deviceType: "loadbalancer", deviceID: strings.TrimPrefix(port.DeviceID, "lb-")}
+		if lb, ok := loadBalancers[device.deviceID]; ok && lb.service != nil {
+			device.service = lb.service
+			device.cluster = lb.service.cluster
+		}
+		return device
+	case strings.HasPrefix(port.DeviceOwner, "network:router") || isRouterInterface(port):
+		return floatingIPDevice{deviceType: "router", deviceID: port.DeviceID}
+	default:
+		return floatingIPDevice{deviceID: port.DeviceID}
+	}
 }
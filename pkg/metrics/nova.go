@@ -3,8 +3,20 @@
 package metrics
 
 import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedserverattributes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/instanceactions"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/limits"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/quotasets"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/serverusage"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/klog/v2"
@@ -15,16 +27,52 @@ var (
 	computeQuotaFloatingIPs     *prometheus.GaugeVec
 	computeQuotaInstances       *prometheus.GaugeVec
 	computeQuotaRAM             *prometheus.GaugeVec
+	computeQuota                *prometheus.GaugeVec
+	computeAbsoluteLimit        *prometheus.GaugeVec
 	serverStatus                *prometheus.GaugeVec
 	serverVolumeAttachment      *prometheus.GaugeVec
 	serverVolumeAttachmentCount *prometheus.GaugeVec
+	serverInfo                  *prometheus.GaugeVec
+	serverFlavorVCPUs           *prometheus.GaugeVec
+	serverFlavorRAM             *prometheus.GaugeVec
+	serverFlavorDisk            *prometheus.GaugeVec
+	serverPowerState            *prometheus.GaugeVec
+	serverCreatedAt             *prometheus.GaugeVec
+	serverUpdatedAt             *prometheus.GaugeVec
+	serverLaunchedAt            *prometheus.GaugeVec
+	serverFault                 *prometheus.GaugeVec
+	serverLastAction            *prometheus.GaugeVec
+	serverLastFailedAction      *prometheus.GaugeVec
+	serverNodeMismatch          *prometheus.GaugeVec
 
 	// possible server states, from https://github.com/openstack/nova/blob/master/nova/objects/fields.py#L949
 	states = []string{"ACTIVE", "BUILDING", "PAUSED", "SUSPENDED", "STOPPED", "RESCUED", "RESIZED", "SOFT_DELETED", "DELETED", "ERROR", "SHELVED", "SHELVED_OFFLOADED"}
 
-	serverLabels = []string{"id", "name"}
+	serverLabels     = []string{"id", "name", "node", "node_role", "k8s_cluster", "owner_cluster"}
+	serverInfoLabels = []string{"flavor_name", "image_id", "availability_zone", "key_name", "vm_state", "task_state", "power_state", "host_id", "hypervisor_hostname"}
+
+	// uuidRegexp and numberRegexp are used to remove highly variable parts from fault messages
+	uuidRegexp   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	numberRegexp = regexp.MustCompile(`\b\d+\b`)
 )
 
+// maxFaultMessageLength is the maximum length of the fault message label
+const maxFaultMessageLength = 128
+
+// serverMicroversion is the compute API microversion used to list servers.
+// Starting with 2.47 the flavor details are embedded into the server.
+const serverMicroversion = "2.47"
+
+// serverWithExt is a server including the attributes of the extensions
+// we are interested in.
+type serverWithExt struct {
+	servers.Server
+	availabilityzones.ServerAvailabilityZoneExt
+	extendedstatus.ServerExtendedStatusExt
+	extendedserverattributes.ServerAttributesExt
+	serverusage.UsageExt
+}
+
 func registerServerMetrics() {
 	computeQuotaCores = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -54,6 +102,20 @@ func registerServerMetrics() {
 		},
 		[]string{"quota_type"},
 	)
+	computeQuota = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("compute_quota"),
+			Help: "Compute quota per resource",
+		},
+		[]string{"resource", "quota_type"},
+	)
+	computeAbsoluteLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("compute_absolute_limit"),
+			Help: "Compute absolute limit as returned by the limits api",
+		},
+		[]string{"limit"},
+	)
 	serverStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: generateName("server_status"),
@@ -75,31 +137,129 @@ func registerServerMetrics() {
 		},
 		append(serverLabels, "volume_id"),
 	)
+	serverInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_info"),
+			Help: "Server information",
+		},
+		append(serverLabels, serverInfoLabels...),
+	)
+	serverFlavorVCPUs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_flavor_vcpus"),
+			Help: "Number of vCPUs of the server flavor",
+		},
+		serverLabels,
+	)
+	serverFlavorRAM = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_flavor_ram_megabytes"),
+			Help: "RAM (in MB) of the server flavor",
+		},
+		serverLabels,
+	)
+	serverFlavorDisk = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_flavor_disk_gigabytes"),
+			Help: "Root disk size (in GB) of the server flavor",
+		},
+		serverLabels,
+	)
+	serverPowerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_power_state"),
+			Help: "Server power state (0: NOSTATE, 1: RUNNING, 3: PAUSED, 4: SHUTDOWN, 6: CRASHED, 7: SUSPENDED)",
+		},
+		serverLabels,
+	)
+	serverCreatedAt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_created_at"),
+			Help: "Server created at",
+		},
+		serverLabels,
+	)
+	serverUpdatedAt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_updated_at"),
+			Help: "Server updated at",
+		},
+		serverLabels,
+	)
+	serverLaunchedAt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_launched_at"),
+			Help: "Server launched at",
+		},
+		serverLabels,
+	)
+
+	serverFault = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_fault_created_at"),
+			Help: "Server fault created at",
+		},
+		append(serverLabels, "code", "message"),
+	)
+	serverLastAction = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_last_action_started_at"),
+			Help: "Server last instance action started at, only for servers in ERROR state or with a task state",
+		},
+		append(serverLabels, "action", "result"),
+	)
+	serverLastFailedAction = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_last_action_failed_at"),
+			Help: "Server last failed instance action started at, only for servers in ERROR state or with a task state",
+		},
+		append(serverLabels, "action"),
+	)
+	serverNodeMismatch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_node_mismatch"),
+			Help: "Server and kubernetes node which do not match",
+		},
+		[]string{"id", "name", "node", "k8s_cluster", "type"},
+	)
 
 	prometheus.MustRegister(computeQuotaCores)
 	prometheus.MustRegister(computeQuotaFloatingIPs)
 	prometheus.MustRegister(computeQuotaInstances)
 	prometheus.MustRegister(computeQuotaRAM)
+	prometheus.MustRegister(computeQuota)
+	prometheus.MustRegister(computeAbsoluteLimit)
 	prometheus.MustRegister(serverStatus)
 	prometheus.MustRegister(serverVolumeAttachmentCount)
 	prometheus.MustRegister(serverVolumeAttachment)
+	prometheus.MustRegister(serverInfo)
+	prometheus.MustRegister(serverFlavorVCPUs)
+	prometheus.MustRegister(serverFlavorRAM)
+	prometheus.MustRegister(serverFlavorDisk)
+	prometheus.MustRegister(serverPowerState)
+	prometheus.MustRegister(serverCreatedAt)
+	prometheus.MustRegister(serverUpdatedAt)
+	prometheus.MustRegister(serverLaunchedAt)
+	prometheus.MustRegister(serverFault)
+	prometheus.MustRegister(serverLastAction)
+	prometheus.MustRegister(serverLastFailedAction)
+	prometheus.MustRegister(serverNodeMismatch)
 }
 
 // PublishServerMetrics makes the list request to the server api and
-// passes the result to a publish function.
-func PublishServerMetrics(client *gophercloud.ServiceClient, tenantID string) error {
+// passes the result to a publish function. The servers are matched with the
+// kubernetes nodes by the provider id of the nodes.
+func PublishServerMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, clusters []*KubernetesCache, tenantID string) error {
 	// first step: gather the data
-	mc := newOpenStackMetric("server", "list")
-	pages, err := servers.List(client, servers.ListOpts{}).AllPages()
-	if mc.Observe(err) != nil {
-		// only warn, maybe the next list will work.
-		klog.Warningf("Unable to list servers: %v", err)
+
+	// get the nodes to add metadata
+	nodes, err := getNodesByServerID(clusters)
+	if err != nil {
 		return err
 	}
-	serversList, err := servers.ExtractServers(pages)
+
+	serversList, err := resources.Servers()
 	if err != nil {
-		// only warn, maybe the next publish will work.
-		klog.Warningf("Unable to extract servers: %v", err)
 		return err
 	}
 
@@ -107,28 +267,120 @@ func PublishServerMetrics(client *gophercloud.ServiceClient, tenantID string) er
 	serverStatus.Reset()
 	serverVolumeAttachmentCount.Reset()
 	serverVolumeAttachment.Reset()
+	serverInfo.Reset()
+	serverFlavorVCPUs.Reset()
+	serverFlavorRAM.Reset()
+	serverFlavorDisk.Reset()
+	serverPowerState.Reset()
+	serverCreatedAt.Reset()
+	serverUpdatedAt.Reset()
+	serverLaunchedAt.Reset()
+	serverFault.Reset()
+	serverLastAction.Reset()
+	serverLastFailedAction.Reset()
+	serverNodeMismatch.Reset()
 
 	// third step: publish the metrics
-	for _, srv := range serversList {
-		publishServerMetric(srv)
+	// without kubernetes every server would be reported as server without node
+	if len(clusters) > 0 {
+		publishServerNodeMismatchMetrics(serversList, nodes)
+	}
+	for _, srv := range filterServers(serversList) {
+		var node *clusterNode
+		if n, ok := nodes[srv.ID]; ok {
+			node = &n
+		}
+		publishServerMetric(srv, node)
+
+		// listing the actions is one request per server, so it is limited to
+		// the servers which failed or are busy with a task
+		if !hasInstanceActionsOfInterest(srv) {
+			continue
+		}
+		mc := newOpenStackMetric("server_instance_actions", "list")
+		pages, err := instanceactions.List(client, srv.ID, instanceactions.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, the server metrics are still valid.
+			klog.Warningf("Unable to list instance actions of server %s: %v", srv.ID, err)
+			continue
+		}
+		actions, err := instanceactions.ExtractInstanceActions(pages)
+		if err != nil {
+			klog.Warningf("Unable to extract instance actions of server %s: %v", srv.ID, err)
+			continue
+		}
+		publishServerActionMetrics(srv, node, actions)
 	}
 
 	// Get compute quotas from OpenStack.
-	mc = newOpenStackMetric("compute_quotasets_detail", "get")
-	quotas, err := quotasets.GetDetail(client, tenantID).Extract()
+	mc := newOpenStackMetric("compute_quotasets_detail", "get")
+	quotaResult := quotasets.GetDetail(client, tenantID)
+	quotas, err := quotaResult.Extract()
 	if mc.Observe(err) != nil {
 		// only warn, maybe the next get will work.
 		klog.Warningf("Unable to get compute quotas: %v", err)
 		return err
 	}
+	quotaResources, err := extractComputeQuotaResources(quotaResult)
+	if err != nil {
+		klog.Warningf("Unable to extract compute quota resources: %v", err)
+		return err
+	}
 	publishComputeQuotaMetrics(quotas)
+	publishComputeQuotaResourceMetrics(quotaResources)
+
+	// Get the absolute compute limits from OpenStack.
+	mc = newOpenStackMetric("compute_limits", "get")
+	var l struct {
+		Limits struct {
+			Absolute map[string]interface{} `json:"absolute"`
+		} `json:"limits"`
+	}
+	err = limits.Get(client, limits.GetOpts{}).ExtractInto(&l)
+	if mc.Observe(err) != nil {
+		// only warn, maybe the next get will work.
+		klog.Warningf("Unable to get compute limits: %v", err)
+		return err
+	}
+	publishComputeAbsoluteLimitMetrics(l.Limits.Absolute)
 
 	return nil
 }
 
 // publishServerMetric extracts data from a server and exposes the metrics via prometheus
-func publishServerMetric(srv servers.Server) {
-	labels := []string{srv.ID, srv.Name}
+func publishServerMetric(srv serverWithExt, node *clusterNode) {
+	labels := append([]string{srv.ID, srv.Name}, extractNodeMetadata(node)...)
+	labels = append(labels, serverCluster(srv))
+
+	infoLabels := append(labels,
+		flavorString(srv.Flavor, "original_name"),
+		imageID(srv.Image),
+		srv.AvailabilityZone,
+		srv.KeyName,
+		srv.VmState,
+		srv.TaskState,
+		srv.PowerState.String(),
+		srv.HostID,
+		srv.HypervisorHostname,
+	)
+	serverInfo.WithLabelValues(infoLabels...).Set(1)
+
+	serverFlavorVCPUs.WithLabelValues(labels...).Set(flavorFloat64(srv.Flavor, "vcpus"))
+	serverFlavorRAM.WithLabelValues(labels...).Set(flavorFloat64(srv.Flavor, "ram"))
+	serverFlavorDisk.WithLabelValues(labels...).Set(flavorFloat64(srv.Flavor, "disk"))
+	serverPowerState.WithLabelValues(labels...).Set(float64(srv.PowerState))
+
+	serverCreatedAt.WithLabelValues(labels...).Set(float64(srv.Created.Unix()))
+	serverUpdatedAt.WithLabelValues(labels...).Set(float64(srv.Updated.Unix()))
+	if !srv.LaunchedAt.IsZero() {
+		serverLaunchedAt.WithLabelValues(labels...).Set(float64(srv.LaunchedAt.Unix()))
+	}
+
+	// the fault is only set for servers in ERROR or DELETED state
+	if srv.Fault.Code != 0 || srv.Fault.Message != "" {
+		faultLabels := append(labels, strconv.Itoa(srv.Fault.Code), normalizeFaultMessage(srv.Fault.Message))
+		serverFault.WithLabelValues(faultLabels...).Set(float64(srv.Fault.Created.Unix()))
+	}
 
 	serverVolumeAttachmentCount.WithLabelValues(labels...).Set(float64(len(srv.AttachedVolumes)))
 	for _, attachedVolumeID := range srv.AttachedVolumes {
@@ -142,6 +394,90 @@ func publishServerMetric(srv servers.Server) {
 	}
 }
 
+// publishServerActionMetrics exposes the last and the last failed instance
+// action of a server. Nova returns the actions ordered by start time, the
+// most recent one first.
+func publishServerActionMetrics(srv serverWithExt, node *clusterNode, actions []instanceactions.InstanceAction) {
+	if len(actions) == 0 {
+		return
+	}
+	labels := append([]string{srv.ID, srv.Name}, extractNodeMetadata(node)...)
+	labels = append(labels, serverCluster(srv))
+
+	last := actions[0]
+	serverLastAction.WithLabelValues(append(labels, last.Action, instanceActionResult(srv, last, true))...).Set(float64(last.StartTime.Unix()))
+
+	for _, action := range actions {
+		if instanceActionResult(srv, action, false) == "failed" {
+			serverLastFailedAction.WithLabelValues(append(labels, action.Action)...).Set(float64(action.StartTime.Unix()))
+			break
+		}
+	}
+}
+
+// publishServerNodeMismatchMetrics exposes the servers and nodes which do not
+// match: active servers of a recognised cluster without node, nodes whose
+// server does not exist or is shut off and nodes which are not ready while
+// their server is active.
+func publishServerNodeMismatchMetrics(serversList []serverWithExt, nodes map[string]clusterNode) {
+	serversByID := map[string]serverWithExt{}
+	for _, srv := range serversList {
+		serversByID[srv.ID] = srv
+		// only the servers of a recognised and exported cluster are expected
+		// to be nodes, a shared project contains other servers, too
+		cluster := serverCluster(srv)
+		if _, ok := nodes[srv.ID]; !ok && srv.Status == "ACTIVE" && cluster != "" && isExportedCluster(cluster) {
+			serverNodeMismatch.WithLabelValues(srv.ID, srv.Name, "", cluster, "server_without_node").Set(1)
+		}
+	}
+
+	for id, node := range nodes {
+		srv, ok := serversByID[id]
+		switch {
+		case !ok:
+			serverNodeMismatch.WithLabelValues(id, "", node.GetName(), node.cluster, "node_without_server").Set(1)
+		case srv.Status == "SHUTOFF":
+			serverNodeMismatch.WithLabelValues(id, srv.Name, node.GetName(), node.cluster, "node_server_shutoff").Set(1)
+		case srv.Status == "ACTIVE" && !isNodeReady(node.Node):
+			serverNodeMismatch.WithLabelValues(id, srv.Name, node.GetName(), node.cluster, "node_not_ready").Set(1)
+		}
+	}
+}
+
+// hasInstanceActionsOfInterest returns true if the server is in ERROR state or
+// has a task state, the actions of other servers are not listed.
+func hasInstanceActionsOfInterest(srv serverWithExt) bool {
+	return srv.Status == "ERROR" || srv.TaskState != ""
+}
+
+// instanceActionResult maps an instance action to a result. Nova sets the
+// message to "Error" if one of the events of the action failed, but the
+// message is empty for successful and running actions. The list does not
+// contain the finish time, so the last action counts as running as long as
+// the server has a task state.
+func instanceActionResult(srv serverWithExt, action instanceactions.InstanceAction, last bool) string {
+	switch {
+	case action.Message == "Error":
+		return "failed"
+	case last && srv.TaskState != "":
+		return "running"
+	default:
+		return "success"
+	}
+}
+
+// normalizeFaultMessage removes ids and numbers from a fault message and
+// truncates it to keep the cardinality of the message label bounded.
+func normalizeFaultMessage(message string) string {
+	message = uuidRegexp.ReplaceAllString(message, "<uuid>")
+	message = numberRegexp.ReplaceAllString(message, "<n>")
+	message = strings.Join(strings.Fields(message), " ")
+	if runes := []rune(message); len(runes) > maxFaultMessageLength {
+		message = string(runes[:maxFaultMessageLength])
+	}
+	return message
+}
+
 // publishComputeQuotaMetrics publishes all compute related quotas
 func publishComputeQuotaMetrics(q quotasets.QuotaDetailSet) {
 	computeQuotaCores.WithLabelValues("in-use").Set(float64(q.Cores.InUse))
@@ -160,3 +496,70 @@ func publishComputeQuotaMetrics(q quotasets.QuotaDetailSet) {
 	computeQuotaRAM.WithLabelValues("reserved").Set(float64(q.RAM.Reserved))
 	computeQuotaRAM.WithLabelValues("limit").Set(float64(q.RAM.Limit))
 }
+
+// extractComputeQuotaResources extracts every quota resource of the quota set.
+// This way new quota resources of nova get exposed without code changes.
+func extractComputeQuotaResources(r quotasets.GetDetailResult) (map[string]quotasets.QuotaDetail, error) {
+	var s struct {
+		QuotaSet map[string]json.RawMessage `json:"quota_set"`
+	}
+	if err := r.ExtractInto(&s); err != nil {
+		return nil, err
+	}
+
+	resources := map[string]quotasets.QuotaDetail{}
+	for resource, raw := range s.QuotaSet {
+		var detail quotasets.QuotaDetail
+		// skip fields which are not a quota detail, e.g. the id
+		if err := json.Unmarshal(raw, &detail); err != nil {
+			continue
+		}
+		resources[resource] = detail
+	}
+	return resources, nil
+}
+
+// publishComputeQuotaResourceMetrics publishes the compute quotas labeled by resource
+func publishComputeQuotaResourceMetrics(resources map[string]quotasets.QuotaDetail) {
+	computeQuota.Reset()
+	for resource, q := range resources {
+		computeQuota.WithLabelValues(resource, "in-use").Set(float64(q.InUse))
+		computeQuota.WithLabelValues(resource, "reserved").Set(float64(q.Reserved))
+		computeQuota.WithLabelValues(resource, "limit").Set(float64(q.Limit))
+	}
+}
+
+// publishComputeAbsoluteLimitMetrics publishes all numeric absolute compute limits
+func publishComputeAbsoluteLimitMetrics(absolute map[string]interface{}) {
+	computeAbsoluteLimit.Reset()
+	for name, value := range absolute {
+		if v, ok := value.(float64); ok {
+			computeAbsoluteLimit.WithLabelValues(name).Set(v)
+		}
+	}
+}
+
+// flavorString returns the value of the given key of an embedded flavor as string
+func flavorString(flavor map[string]interface{}, key string) string {
+	if v, ok := flavor[key]; ok && v != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+// flavorFloat64 returns the numeric value of the given key of an embedded flavor
+func flavorFloat64(flavor map[string]interface{}, key string) float64 {
+	if v, ok := flavor[key].(float64); ok {
+		return v
+	}
+	return 0
+}
+
+// imageID returns the id of the image the server was booted from.
+// It is empty for servers booted from volume.
+func imageID(image map[string]interface{}) string {
+	if id, ok := image["id"].(string); ok {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/quotas"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	loadbalancerQuota                *prometheus.GaugeVec
+	loadbalancerFlavorInfo           *prometheus.GaugeVec
+	loadbalancerAvailabilityZoneInfo *prometheus.GaugeVec
+)
+
+// octaviaAvailabilityZone is an octavia availability zone, which is not
+// implemented by gophercloud.
+type octaviaAvailabilityZone struct {
+	Name                      string `json:"name"`
+	Description               string `json:"description"`
+	Enabled                   bool   `json:"enabled"`
+	AvailabilityZoneProfileID string `json:"availability_zone_profile_id"`
+}
+
+func registerOctaviaMetrics() {
+	loadbalancerQuota = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_quota"),
+			Help: "Load balancer quota per resource",
+		},
+		[]string{"resource", "quota_type"},
+	)
+	loadbalancerFlavorInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_flavor_info"),
+			Help: "Load balancer flavor information",
+		},
+		[]string{"id", "name", "description", "enabled", "flavor_profile_id"},
+	)
+	loadbalancerAvailabilityZoneInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("loadbalancer_availability_zone_info"),
+			Help: "Load balancer availability zone information",
+		},
+		[]string{"name", "description", "enabled", "availability_zone_profile_id"},
+	)
+
+	prometheus.MustRegister(loadbalancerQuota)
+	prometheus.MustRegister(loadbalancerFlavorInfo)
+	prometheus.MustRegister(loadbalancerAvailabilityZoneInfo)
+}
+
+// PublishOctaviaMetrics makes the requests for the load balancer quotas, the
+// flavors and the availability zones and passes the result to the publish
+// functions. The usage of the quotas is counted from the resources of the
+// project, as octavia does not return it. Neutron lbaas does not implement
+// these apis, the availability zones exist since stein and the apis may be
+// forbidden by the octavia policy, so a not found or forbidden response only
+// skips the affected metrics.
+func PublishOctaviaMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, tenantID string) error {
+	var errs []error
+	if err := publishLoadBalancerQuotas(client, resources, tenantID); err != nil {
+		errs = append(errs, err)
+	}
+	if err := publishLoadBalancerFlavors(client); err != nil {
+		errs = append(errs, err)
+	}
+	if err := publishLoadBalancerAvailabilityZones(client); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors while getting octavia metrics, first error: %v", len(errs), errs[0])
+	}
+	return nil
+}
+
+// publishLoadBalancerQuotas gets the load balancer quotas, counts their usage
+// and exposes them via prometheus
+func publishLoadBalancerQuotas(client *gophercloud.ServiceClient, resources *OpenStackResources, tenantID string) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("loadbalancer_quotas", "get")
+	q, err := quotas.Get(client, tenantID).Extract()
+	if mc.Observe(err) != nil {
+		switch err.(type) {
+		case gophercloud.ErrDefault403, gophercloud.ErrDefault404:
+			// reset metrics if the api is not usable to not publish them anymore
+			loadbalancerQuota.Reset()
+			klog.V(4).Infof("skipping load balancer quota metrics as the api is not usable: %v", err)
+			return nil
+		}
+		// only warn, maybe the next get will work.
+		klog.Warningf("Unable to get load balancer quotas: %v", err)
+		return err
+	}
+
+	used, err := getLoadBalancerQuotaUsage(resources, tenantID)
+	if err != nil {
+		return err
+	}
+
+	// second step: reset the old metrics
+	loadbalancerQuota.Reset()
+
+	// third step: publish the metrics
+	limits := map[string]int{
+		"loadbalancer":  q.Loadbalancer,
+		"listener":      q.Listener,
+		"pool":          q.Pool,
+		"member":        q.Member,
+		"healthmonitor": q.Healthmonitor,
+		"l7policy":      q.L7Policy,
+		"l7rule":        q.L7Rule,
+	}
+	for resource, limit := range limits {
+		loadbalancerQuota.WithLabelValues(resource, "in-use").Set(float64(used[resource]))
+		loadbalancerQuota.WithLabelValues(resource, "limit").Set(float64(limit))
+	}
+
+	return nil
+}
+
+// publishLoadBalancerFlavors lists the load balancer flavors and exposes them
+// via prometheus
+func publishLoadBalancerFlavors(client *gophercloud.ServiceClient) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("loadbalancer_flavor", "list")
+	pages, err := flavors.List(client, flavors.ListOpts{}).AllPages()
+	if mc.Observe(err) != nil {
+		switch err.(type) {
+		case gophercloud.ErrDefault403, gophercloud.ErrDefault404:
+			// reset metrics if the api is not usable to not publish them anymore
+			loadbalancerFlavorInfo.Reset()
+			klog.V(4).Infof("skipping load balancer flavor metrics as the api is not usable: %v", err)
+			return nil
+		}
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list load balancer flavors: %v", err)
+		return err
+	}
+	flavorList, err := flavors.ExtractFlavors(pages)
+	if err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract load balancer flavors: %v", err)
+		return err
+	}
+
+	// second step: reset the old metrics
+	loadbalancerFlavorInfo.Reset()
+
+	// third step: publish the metrics
+	for _, flavor := range flavorList {
+		loadbalancerFlavorInfo.WithLabelValues(flavor.ID, flavor.Name, flavor.Description,
+			strconv.FormatBool(flavor.Enabled), flavor.FlavorProfileId).Set(1)
+	}
+
+	return nil
+}
+
+// publishLoadBalancerAvailabilityZones lists the load balancer availability
+// zones and exposes them via prometheus
+func publishLoadBalancerAvailabilityZones(client *gophercloud.ServiceClient) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("loadbalancer_availability_zone", "list")
+	var az struct {
+		AvailabilityZones []octaviaAvailabilityZone `json:"availability_zones"`
+	}
+	_, err := client.Get(client.ServiceURL("lbaas", "availabilityzones"), &az, nil)
+	if mc.Observe(err) != nil {
+		switch err.(type) {
+		case gophercloud.ErrDefault403, gophercloud.ErrDefault404:
+			// reset metrics if the api is not usable to not publish them anymore
+			loadbalancerAvailabilityZoneInfo.Reset()
+			klog.V(4).Infof("skipping load balancer availability zone metrics as the api is not usable: %v", err)
+			return nil
+		}
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list load balancer availability zones: %v", err)
+		return err
+	}
+
+	// second step: reset the old metrics
+	loadbalancerAvailabilityZoneInfo.Reset()
+
+	// third step: publish the metrics
+	for _, zone := range az.AvailabilityZones {
+		loadbalancerAvailabilityZoneInfo.WithLabelValues(zone.Name, zone.Description,
+			strconv.FormatBool(zone.Enabled), zone.AvailabilityZoneProfileID).Set(1)
+	}
+
+	return nil
+}
+
+// getLoadBalancerQuotaUsage counts the load balancer resources of the project
+// per quota resource. The resources are shared with the load balancer
+// collector, an admin may see the resources of other projects, too.
+func getLoadBalancerQuotaUsage(resources *OpenStackResources, tenantID string) (map[string]int, error) {
+	used := map[string]int{}
+
+	loadBalancerList, err := resources.LoadBalancers()
+	if err != nil {
+		return nil, err
+	}
+	for _, lb := range loadBalancerList {
+		if isProjectResource(lb.ProjectID, tenantID) {
+			used["loadbalancer"]++
+		}
+	}
+
+	listenerList, err := resources.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	for _, listener := range listenerList {
+		if isProjectResource(listener.ProjectID, tenantID) {
+			used["listener"]++
+		}
+	}
+
+	poolList, err := resources.Pools()
+	if err != nil {
+		return nil, err
+	}
+	for _, pool := range poolList {
+		if isProjectResource(pool.ProjectID, tenantID) {
+			used["pool"]++
+			used["member"] += len(pool.Members)
+		}
+	}
+
+	monitorList, err := resources.HealthMonitors()
+	if err != nil {
+		return nil, err
+	}
+	for _, monitor := range monitorList {
+		if isProjectResource(monitor.ProjectID, tenantID) {
+			used["healthmonitor"]++
+		}
+	}
+
+	l7PolicyList, err := resources.L7Policies()
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range l7PolicyList {
+		if isProjectResource(policy.ProjectID, tenantID) {
+			used["l7policy"]++
+			used["l7rule"] += len(policy.Rules)
+		}
+	}
+
+	return used, nil
+}
+
+// isProjectResource returns true if the resource belongs to the project. All
+// resources belong to it, if the project id is unknown.
+func isProjectResource(projectID, tenantID string) bool {
+	return tenantID == "" || projectID == tenantID
+}
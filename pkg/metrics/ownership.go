@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v2/volumes"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/amphorae"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+)
+
+const (
+	// volume metadata of the cinder csi driver with the cluster name of the volume
+	cinderCSIClusterMetadataKey = "cinder.csi.openstack.org/cluster"
+	// share metadata of the manila csi driver with the cluster name of the share
+	manilaCSIClusterMetadataKey = "manila.csi.openstack.org/cluster"
+	// name of the regexp group with the cluster name in NodeNamePattern
+	nodeNamePatternClusterGroup = "cluster"
+)
+
+// ClusterOwnership configures how the kubernetes cluster owning an openstack
+// resource is recognised. The cinder csi driver and cloud-provider-openstack
+// mark their volumes and load balancers with the cluster name, the servers
+// are recognised by their metadata, tags or name.
+type ClusterOwnership struct {
+	// ServerKey is the server metadata key with the cluster name as value.
+	// Server tags "<ServerKey>=<cluster>" are recognised, too.
+	ServerKey string
+	// NodeNamePattern matches the names of the servers of the clusters, the
+	// group named "cluster" contains the cluster name.
+	NodeNamePattern *regexp.Regexp
+	// Clusters are the clusters whose resources are exported. If it is
+	// empty, the resources of all clusters and without cluster are exported.
+	Clusters []string
+}
+
+var ownership ClusterOwnership
+
+// SetClusterOwnership sets how the owning cluster of the openstack resources
+// is recognised and which clusters are exported.
+func SetClusterOwnership(o ClusterOwnership) {
+	ownership = o
+}
+
+// isExportedCluster returns true if the resources of the owning cluster are exported
+func isExportedCluster(cluster string) bool {
+	return len(ownership.Clusters) == 0 || contains(ownership.Clusters, cluster)
+}
+
+// volumeCluster returns the owning cluster of a cinder volume
+func volumeCluster(v volumes.Volume) string {
+	return v.Metadata[cinderCSIClusterMetadataKey]
+}
+
+// shareCluster returns the owning cluster of a manila share
+func shareCluster(share shares.Share) string {
+	return share.Metadata[manilaCSIClusterMetadataKey]
+}
+
+// serverCluster returns the owning cluster of a server by its metadata, its
+// tags or its name
+func serverCluster(srv serverWithExt) string {
+	if ownership.ServerKey != "" {
+		if cluster := srv.Metadata[ownership.ServerKey]; cluster != "" {
+			return cluster
+		}
+		if srv.Tags != nil {
+			for _, tag := range *srv.Tags {
+				if strings.HasPrefix(tag, ownership.ServerKey+"=") {
+					return strings.TrimPrefix(tag, ownership.ServerKey+"=")
+				}
+			}
+		}
+	}
+	if ownership.NodeNamePattern != nil {
+		if match := ownership.NodeNamePattern.FindStringSubmatch(srv.Name); match != nil {
+			return match[ownership.NodeNamePattern.SubexpIndex(nodeNamePatternClusterGroup)]
+		}
+	}
+	return ""
+}
+
+// filterVolumes returns the volumes of the exported clusters
+func filterVolumes(vList []volumes.Volume) []volumes.Volume {
+	if len(ownership.Clusters) == 0 {
+		return vList
+	}
+	var filtered []volumes.Volume
+	for _, v := range vList {
+		if isExportedCluster(volumeCluster(v)) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// filterLoadBalancers returns the load balancers of the exported clusters, the
+// owning cluster is part of the load balancer name
+func filterLoadBalancers(lbs []loadBalancerWithService) []loadBalancerWithService {
+	if len(ownership.Clusters) == 0 {
+		return lbs
+	}
+	var filtered []loadBalancerWithService
+	for _, lb := range lbs {
+		if isExportedCluster(lb.cluster) {
+			filtered = append(filtered, lb)
+		}
+	}
+	return filtered
+}
+
+// filterServers returns the servers of the exported clusters
+func filterServers(serversList []serverWithExt) []serverWithExt {
+	if len(ownership.Clusters) == 0 {
+		return serversList
+	}
+	var filtered []serverWithExt
+	for _, srv := range serversList {
+		if isExportedCluster(serverCluster(srv)) {
+			filtered = append(filtered, srv)
+		}
+	}
+	return filtered
+}
+
+// filterShares returns the manila shares of the exported clusters
+func filterShares(sharesList []shares.Share) []shares.Share {
+	if len(ownership.Clusters) == 0 {
+		return sharesList
+	}
+	var filtered []shares.Share
+	for _, share := range sharesList {
+		if isExportedCluster(shareCluster(share)) {
+			filtered = append(filtered, share)
+		}
+	}
+	return filtered
+}
+
+// deviceClusters returns the owning cluster of every server and load balancer
+// by its id. The ports, floating ips and amphorae belong to the cluster of
+// their server or load balancer.
+func deviceClusters(serversList []serverWithExt, lbs []loadbalancers.LoadBalancer) map[string]string {
+	clusters := map[string]string{}
+	for _, srv := range serversList {
+		clusters[srv.ID] = serverCluster(srv)
+	}
+	for _, lb := range lbs {
+		cluster, _, _, _ := parseLoadBalancerName(lb.Name)
+		clusters[lb.ID] = cluster
+	}
+	return clusters
+}
+
+// filterPorts returns the ports whose server or load balancer belongs to an
+// exported cluster
+func filterPorts(portList []ports.Port, devices map[string]string) []ports.Port {
+	if len(ownership.Clusters) == 0 {
+		return portList
+	}
+	var filtered []ports.Port
+	for _, port := range portList {
+		if isExportedCluster(devices[portDeviceID(port)]) {
+			filtered = append(filtered, port)
+		}
+	}
+	return filtered
+}
+
+// filterFloatingIPs returns the floating ips whose port belongs to a server or
+// load balancer of an exported cluster
+func filterFloatingIPs(floatingIPList []floatingips.FloatingIP, portsByID map[string]ports.Port, devices map[string]string) []floatingips.FloatingIP {
+	if len(ownership.Clusters) == 0 {
+		return floatingIPList
+	}
+	var filtered []floatingips.FloatingIP
+	for _, fip := range floatingIPList {
+		port, ok := portsByID[fip.PortID]
+		if ok && isExportedCluster(devices[portDeviceID(port)]) {
+			filtered = append(filtered, fip)
+		}
+	}
+	return filtered
+}
+
+// filterAmphorae returns the amphorae of the load balancers of the exported
+// clusters
+func filterAmphorae(amphoraList []amphorae.Amphora, devices map[string]string) []amphorae.Amphora {
+	if len(ownership.Clusters) == 0 {
+		return amphoraList
+	}
+	var filtered []amphorae.Amphora
+	for _, amphora := range amphoraList {
+		if isExportedCluster(devices[amphora.LoadbalancerID]) {
+			filtered = append(filtered, amphora)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/amphorae"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+)
+
+func newServer(id, name string, metadata map[string]string, tags ...string) serverWithExt {
+	var srv serverWithExt
+	srv.ID = id
+	srv.Name = name
+	srv.Metadata = metadata
+	if tags != nil {
+		srv.Tags = &tags
+	}
+	return srv
+}
+
+func TestServerCluster(t *testing.T) {
+	defer func(o ClusterOwnership) { ownership = o }(ownership)
+
+	pattern := regexp.MustCompile(`^(?P<cluster>[a-z0-9-]+)-(master|worker)-\d+$`)
+	tests := []struct {
+		name      string
+		ownership ClusterOwnership
+		server    serverWithExt
+		want      string
+	}{
+		{
+			name:   "nothing configured",
+			server: newServer("1", "prod-worker-1", map[string]string{"cluster": "prod"}, "cluster=prod"),
+			want:   "",
+		},
+		{
+			name:      "metadata",
+			ownership: ClusterOwnership{ServerKey: "cluster"},
+			server:    newServer("1", "node", map[string]string{"cluster": "prod"}),
+			want:      "prod",
+		},
+		{
+			name:      "metadata before tag",
+			ownership: ClusterOwnership{ServerKey: "cluster"},
+			server:    newServer("1", "node", map[string]string{"cluster": "prod"}, "cluster=stage"),
+			want:      "prod",
+		},
+		{
+			name:      "tag",
+			ownership: ClusterOwnership{ServerKey: "cluster"},
+			server:    newServer("1", "node", nil, "other=dev", "cluster=stage"),
+			want:      "stage",
+		},
+		{
+			name:      "tag of another key",
+			ownership: ClusterOwnership{ServerKey: "cluster"},
+			server:    newServer("1", "node", nil, "clusters=stage"),
+			want:      "",
+		},
+		{
+			name:      "metadata before name",
+			ownership: ClusterOwnership{ServerKey: "cluster", NodeNamePattern: pattern},
+			server:    newServer("1", "prod-worker-1", map[string]string{"cluster": "stage"}),
+			want:      "stage",
+		},
+		{
+			name:      "name",
+			ownership: ClusterOwnership{ServerKey: "cluster", NodeNamePattern: pattern},
+			server:    newServer("1", "prod-eu-1-master-0", nil),
+			want:      "prod-eu-1",
+		},
+		{
+			name:      "name not matching",
+			ownership: ClusterOwnership{NodeNamePattern: pattern},
+			server:    newServer("1", "jumphost", nil),
+			want:      "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetClusterOwnership(tt.ownership)
+			if got := serverCluster(tt.server); got != tt.want {
+				t.Errorf("serverCluster() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceClusters(t *testing.T) {
+	defer func(o ClusterOwnership) { ownership = o }(ownership)
+	SetClusterOwnership(ClusterOwnership{ServerKey: "cluster"})
+
+	serversList := []serverWithExt{
+		newServer("srv-1", "node", map[string]string{"cluster": "prod"}),
+		newServer("srv-2", "jumphost", nil),
+	}
+	lbs := []loadbalancers.LoadBalancer{
+		{ID: "lb-1", Name: "kube_service_stage_default_web"},
+		{ID: "lb-2", Name: "hand-made"},
+	}
+	want := map[string]string{"srv-1": "prod", "srv-2": "", "lb-1": "stage", "lb-2": ""}
+
+	got := deviceClusters(serversList, lbs)
+	if len(got) != len(want) {
+		t.Fatalf("deviceClusters() = %v, want %v", got, want)
+	}
+	for id, cluster := range want {
+		if c, ok := got[id]; !ok || c != cluster {
+			t.Errorf("cluster of device %s = %q, want %q", id, c, cluster)
+		}
+	}
+}
+
+func TestFilterResources(t *testing.T) {
+	defer func(o ClusterOwnership) { ownership = o }(ownership)
+
+	devices := map[string]string{"srv-1": "prod", "srv-2": "stage", "srv-3": "", "lb-1": "prod", "lb-2": "stage"}
+	portList := []ports.Port{
+		{ID: "port-srv-1", DeviceOwner: "compute:nova", DeviceID: "srv-1"},
+		{ID: "port-srv-2", DeviceOwner: "compute:nova", DeviceID: "srv-2"},
+		{ID: "port-srv-3", DeviceOwner: "compute:nova", DeviceID: "srv-3"},
+		{ID: "port-lb-1", DeviceOwner: "Octavia", DeviceID: "lb-lb-1"},
+		{ID: "port-lb-2", DeviceOwner: "neutron:LOADBALANCERV2", DeviceID: "lb-2"},
+		{ID: "port-router", DeviceOwner: "network:router_interface", DeviceID: "router-1"},
+		{ID: "port-deleted", DeviceOwner: "compute:nova", DeviceID: "srv-deleted"},
+	}
+	portsByID := map[string]ports.Port{}
+	for _, port := range portList {
+		portsByID[port.ID] = port
+	}
+	floatingIPList := []floatingips.FloatingIP{
+		{ID: "fip-srv-1", PortID: "port-srv-1"},
+		{ID: "fip-lb-2", PortID: "port-lb-2"},
+		{ID: "fip-lb-1", PortID: "port-lb-1"},
+		{ID: "fip-router", PortID: "port-router"},
+		{ID: "fip-unassociated"},
+	}
+	amphoraList := []amphorae.Amphora{
+		{ID: "amphora-lb-1", LoadbalancerID: "lb-1"},
+		{ID: "amphora-lb-2", LoadbalancerID: "lb-2"},
+		{ID: "amphora-spare"},
+	}
+	sharesList := []shares.Share{
+		{ID: "share-prod", Metadata: map[string]string{manilaCSIClusterMetadataKey: "prod"}},
+		{ID: "share-stage", Metadata: map[string]string{manilaCSIClusterMetadataKey: "stage"}},
+		{ID: "share-hand-made"},
+	}
+
+	tests := []struct {
+		name     string
+		clusters []string
+		ports    []string
+		fips     []string
+		amphorae []string
+		shares   []string
+	}{
+		{
+			name:     "no filter",
+			ports:    []string{"port-srv-1", "port-srv-2", "port-srv-3", "port-lb-1", "port-lb-2", "port-router", "port-deleted"},
+			fips:     []string{"fip-srv-1", "fip-lb-2", "fip-lb-1", "fip-router", "fip-unassociated"},
+			amphorae: []string{"amphora-lb-1", "amphora-lb-2", "amphora-spare"},
+			shares:   []string{"share-prod", "share-stage", "share-hand-made"},
+		},
+		{
+			name:     "one cluster",
+			clusters: []string{"prod"},
+			ports:    []string{"port-srv-1", "port-lb-1"},
+			fips:     []string{"fip-srv-1", "fip-lb-1"},
+			amphorae: []string{"amphora-lb-1"},
+			shares:   []string{"share-prod"},
+		},
+		{
+			name:     "several clusters",
+			clusters: []string{"prod", "stage"},
+			ports:    []string{"port-srv-1", "port-srv-2", "port-lb-1", "port-lb-2"},
+			fips:     []string{"fip-srv-1", "fip-lb-2", "fip-lb-1"},
+			amphorae: []string{"amphora-lb-1", "amphora-lb-2"},
+			shares:   []string{"share-prod", "share-stage"},
+		},
+		{
+			name:     "unknown cluster",
+			clusters: []string{"dev"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetClusterOwnership(ClusterOwnership{Clusters: tt.clusters})
+
+			var got []string
+			for _, port := range filterPorts(portList, devices) {
+				got = append(got, port.ID)
+			}
+			if !equalStrings(got, tt.ports) {
+				t.Errorf("filterPorts() = %v, want %v", got, tt.ports)
+			}
+
+			got = nil
+			for _, fip := range filterFloatingIPs(floatingIPList, portsByID, devices) {
+				got = append(got, fip.ID)
+			}
+			if !equalStrings(got, tt.fips) {
+				t.Errorf("filterFloatingIPs() = %v, want %v", got, tt.fips)
+			}
+
+			got = nil
+			for _, amphora := range filterAmphorae(amphoraList, devices) {
+				got = append(got, amphora.ID)
+			}
+			if !equalStrings(got, tt.amphorae) {
+				t.Errorf("filterAmphorae() = %v, want %v", got, tt.amphorae)
+			}
+
+			got = nil
+			for _, share := range filterShares(sharesList) {
+				got = append(got, share.ID)
+			}
+			if !equalStrings(got, tt.shares) {
+				t.Errorf("filterShares() = %v, want %v", got, tt.shares)
+			}
+		})
+	}
+}
+
+func TestIsOrphanedPort(t *testing.T) {
+	devices := map[string]string{"srv-1": "", "lb-1": "prod"}
+	tests := []struct {
+		port ports.Port
+		want bool
+	}{
+		{port: ports.Port{DeviceOwner: "compute:nova", DeviceID: "srv-1"}, want: false},
+		{port: ports.Port{DeviceOwner: "compute:az-1", DeviceID: "srv-deleted"}, want: true},
+		{port: ports.Port{DeviceOwner: "Octavia", DeviceID: "lb-lb-1"}, want: false},
+		{port: ports.Port{DeviceOwner: "Octavia", DeviceID: "lb-deleted"}, want: true},
+		{port: ports.Port{DeviceOwner: "neutron:LOADBALANCERV2", DeviceID: "lb-1"}, want: false},
+		{port: ports.Port{DeviceOwner: "network:dhcp", DeviceID: "dhcp-1"}, want: false},
+		{port: ports.Port{DeviceOwner: "compute:nova"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join([]string{tt.port.DeviceOwner, tt.port.DeviceID}, "/"), func(t *testing.T) {
+			if got := isOrphanedPort(tt.port, devices); got != tt.want {
+				t.Errorf("isOrphanedPort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	neutronPortStatus       *prometheus.GaugeVec
+	neutronPortAdminStateUp *prometheus.GaugeVec
+	neutronPortFixedIPs     *prometheus.GaugeVec
+	neutronPortCreatedAt    *prometheus.GaugeVec
+	neutronPortOrphaned     *prometheus.GaugeVec
+
+	// Status from https://docs.openstack.org/api-ref/network/v2/index.html#show-port-details
+	portStates = []string{"ACTIVE", "DOWN", "BUILD", "ERROR"}
+
+	portLabels = []string{"id", "name", "network_id", "device_owner", "device_id", "owner_cluster"}
+)
+
+func registerPortMetrics() {
+	neutronPortStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_port_status"),
+			Help: "Neutron port status",
+		},
+		append(portLabels, "status"),
+	)
+	neutronPortAdminStateUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_port_admin_state_up"),
+			Help: "Neutron port admin state up",
+		},
+		portLabels,
+	)
+	neutronPortFixedIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_port_fixed_ips"),
+			Help: "Number of fixed ips of a neutron port",
+		},
+		portLabels,
+	)
+	neutronPortCreatedAt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_port_created_at"),
+			Help: "Neutron port created at",
+		},
+		portLabels,
+	)
+	neutronPortOrphaned = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_port_orphaned"),
+			Help: "Neutron port whose device (server or load balancer) does not exist anymore",
+		},
+		portLabels,
+	)
+
+	prometheus.MustRegister(neutronPortStatus)
+	prometheus.MustRegister(neutronPortAdminStateUp)
+	prometheus.MustRegister(neutronPortFixedIPs)
+	prometheus.MustRegister(neutronPortCreatedAt)
+	prometheus.MustRegister(neutronPortOrphaned)
+}
+
+// PublishPortMetrics passes the ports to a publish function. The servers and
+// load balancers are used to detect ports whose device does not exist anymore
+// and to filter the ports by the owning cluster of their device.
+func PublishPortMetrics(resources *OpenStackResources) error {
+	// first step: gather the data
+	portList, err := resources.Ports()
+	if err != nil {
+		return err
+	}
+	serversList, err := resources.Servers()
+	if err != nil {
+		return err
+	}
+	loadBalancerList, err := resources.LoadBalancers()
+	if err != nil {
+		return err
+	}
+
+	devices := deviceClusters(serversList, loadBalancerList)
+	portList = filterPorts(portList, devices)
+
+	// second step: reset the old metrics
+	neutronPortStatus.Reset()
+	neutronPortAdminStateUp.Reset()
+	neutronPortFixedIPs.Reset()
+	neutronPortCreatedAt.Reset()
+	neutronPortOrphaned.Reset()
+
+	// third step: publish the metrics
+	for _, port := range portList {
+		publishPortMetric(port, devices)
+	}
+
+	return nil
+}
+
+// publishPortMetric extracts data from a port and exposes the metrics via prometheus
+func publishPortMetric(port ports.Port, devices map[string]string) {
+	labels := []string{port.ID, port.Name, port.NetworkID, port.DeviceOwner, port.DeviceID, devices[portDeviceID(port)]}
+
+	neutronPortAdminStateUp.WithLabelValues(labels...).Set(boolFloat64(port.AdminStateUp))
+	neutronPortFixedIPs.WithLabelValues(labels...).Set(float64(len(port.FixedIPs)))
+	neutronPortCreatedAt.WithLabelValues(labels...).Set(float64(port.CreatedAt.Unix()))
+	neutronPortOrphaned.WithLabelValues(labels...).Set(boolFloat64(isOrphanedPort(port, devices)))
+
+	for _, status := range portStates {
+		statusLabels := append(labels, status)
+		neutronPortStatus.WithLabelValues(statusLabels...).Set(boolFloat64(port.Status == status))
+	}
+}
+
+// isOrphanedPort returns true if the port belongs to a server or load balancer
+// which does not exist anymore.
+func isOrphanedPort(port ports.Port, devices map[string]string) bool {
+	deviceID := portDeviceID(port)
+	if deviceID == "" {
+		return false
+	}
+	_, ok := devices[deviceID]
+	return !ok
+}
+
+// portDeviceID returns the id of the server or load balancer of a port, it is
+// empty for the ports of other devices.
+func portDeviceID(port ports.Port) string {
+	switch {
+	case strings.HasPrefix(port.DeviceOwner, "compute:"):
+		return port.DeviceID
+	case port.DeviceOwner == "Octavia":
+		// octavia uses the load balancer id prefixed by "lb-" as device id of the vip port
+		return strings.TrimPrefix(port.DeviceID, "lb-")
+	case port.DeviceOwner == "neutron:LOADBALANCERV2":
+		return port.DeviceID
+	default:
+		return ""
+	}
+}
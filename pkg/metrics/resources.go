@@ -0,0 +1,254 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v2/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/klog/v2"
+)
+
+// OpenStackResources lists the openstack resources which are needed by several
+// collectors. A resource is listed at its first use and the result is shared
+// by all collectors, so it is listed only once per refresh. A new
+// OpenStackResources has to be created for every refresh.
+type OpenStackResources struct {
+	cinderClient       *gophercloud.ServiceClient
+	computeClient      *gophercloud.ServiceClient
+	neutronClient      *gophercloud.ServiceClient
+	loadbalancerClient *gophercloud.ServiceClient
+	tenantID           string
+
+	// listed holds the result of every listed resource
+	listed map[string]listResult
+}
+
+type listResult struct {
+	items interface{}
+	err   error
+}
+
+// NewOpenStackResources creates the resources of a refresh.
+func NewOpenStackResources(cinderClient, computeClient, neutronClient, loadbalancerClient *gophercloud.ServiceClient, tenantID string) *OpenStackResources {
+	return &OpenStackResources{
+		cinderClient:       cinderClient,
+		computeClient:      computeClient,
+		neutronClient:      neutronClient,
+		loadbalancerClient: loadbalancerClient,
+		tenantID:           tenantID,
+		listed:             map[string]listResult{},
+	}
+}
+
+// listOnce calls list at the first use of a resource and returns its result
+// afterwards.
+func (r *OpenStackResources) listOnce(resource string, list func() (interface{}, error)) (interface{}, error) {
+	result, ok := r.listed[resource]
+	if !ok {
+		result.items, result.err = list()
+		r.listed[resource] = result
+	}
+	return result.items, result.err
+}
+
+// Volumes returns the cinder volumes.
+func (r *OpenStackResources) Volumes() ([]volumes.Volume, error) {
+	items, err := r.listOnce("volumes", func() (interface{}, error) {
+		mc := newOpenStackMetric("volume", "list")
+		pages, err := volumes.List(r.cinderClient, volumes.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list volumes: %v", err)
+			return nil, err
+		}
+		volumesList, err := volumes.ExtractVolumes(pages)
+		if err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract volumes: %v", err)
+			return nil, err
+		}
+		return volumesList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]volumes.Volume), nil
+}
+
+// Servers returns the servers including the attributes of the extensions.
+func (r *OpenStackResources) Servers() ([]serverWithExt, error) {
+	items, err := r.listOnce("servers", func() (interface{}, error) {
+		// use a copy of the client to not change the microversion of the other requests
+		client := *r.computeClient
+		client.Microversion = serverMicroversion
+		mc := newOpenStackMetric("server", "list")
+		pages, err := servers.List(&client, servers.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list servers: %v", err)
+			return nil, err
+		}
+		var serversList []serverWithExt
+		if err := servers.ExtractServersInto(pages, &serversList); err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract servers: %v", err)
+			return nil, err
+		}
+		return serversList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]serverWithExt), nil
+}
+
+// Ports returns the ports of the project.
+func (r *OpenStackResources) Ports() ([]ports.Port, error) {
+	items, err := r.listOnce("ports", func() (interface{}, error) {
+		mc := newOpenStackMetric("port", "list")
+		pages, err := ports.List(r.neutronClient, ports.ListOpts{ProjectID: r.tenantID}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list ports: %v", err)
+			return nil, err
+		}
+		portList, err := ports.ExtractPorts(pages)
+		if err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract ports: %v", err)
+			return nil, err
+		}
+		return portList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]ports.Port), nil
+}
+
+// LoadBalancers returns the load balancers.
+func (r *OpenStackResources) LoadBalancers() ([]loadbalancers.LoadBalancer, error) {
+	items, err := r.listOnce("loadbalancers", func() (interface{}, error) {
+		mc := newOpenStackMetric("loadbalancer", "list")
+		pages, err := loadbalancers.List(r.loadbalancerClient, loadbalancers.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list load balancers: %v", err)
+			return nil, err
+		}
+		loadBalancerList, err := loadbalancers.ExtractLoadBalancers(pages)
+		if err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract load balancers: %v", err)
+			return nil, err
+		}
+		return loadBalancerList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]loadbalancers.LoadBalancer), nil
+}
+
+// Listeners returns the load balancer listeners.
+func (r *OpenStackResources) Listeners() ([]listeners.Listener, error) {
+	items, err := r.listOnce("listeners", func() (interface{}, error) {
+		mc := newOpenStackMetric("loadbalancer_listener", "list")
+		pages, err := listeners.List(r.loadbalancerClient, listeners.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list load balancer listeners: %v", err)
+			return nil, err
+		}
+		listenerList, err := listeners.ExtractListeners(pages)
+		if err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract load balancer listeners: %v", err)
+			return nil, err
+		}
+		return listenerList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]listeners.Listener), nil
+}
+
+// Pools returns the load balancer pools.
+func (r *OpenStackResources) Pools() ([]pools.Pool, error) {
+	items, err := r.listOnce("pools", func() (interface{}, error) {
+		mc := newOpenStackMetric("loadbalancer_pool", "list")
+		pages, err := pools.List(r.loadbalancerClient, pools.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list load balancer pools: %v", err)
+			return nil, err
+		}
+		poolList, err := pools.ExtractPools(pages)
+		if err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract load balancer pools: %v", err)
+			return nil, err
+		}
+		return poolList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]pools.Pool), nil
+}
+
+// L7Policies returns the load balancer L7 policies.
+func (r *OpenStackResources) L7Policies() ([]l7policies.L7Policy, error) {
+	items, err := r.listOnce("l7policies", func() (interface{}, error) {
+		mc := newOpenStackMetric("loadbalancer_l7policy", "list")
+		pages, err := l7policies.List(r.loadbalancerClient, l7policies.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list load balancer l7 policies: %v", err)
+			return nil, err
+		}
+		l7PolicyList, err := l7policies.ExtractL7Policies(pages)
+		if err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract load balancer l7 policies: %v", err)
+			return nil, err
+		}
+		return l7PolicyList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]l7policies.L7Policy), nil
+}
+
+// HealthMonitors returns the load balancer health monitors.
+func (r *OpenStackResources) HealthMonitors() ([]monitors.Monitor, error) {
+	items, err := r.listOnce("healthmonitors", func() (interface{}, error) {
+		mc := newOpenStackMetric("loadbalancer_healthmonitor", "list")
+		pages, err := monitors.List(r.loadbalancerClient, monitors.ListOpts{}).AllPages()
+		if mc.Observe(err) != nil {
+			// only warn, maybe the next list will work.
+			klog.Warningf("Unable to list load balancer health monitors: %v", err)
+			return nil, err
+		}
+		monitorList, err := monitors.ExtractMonitors(pages)
+		if err != nil {
+			// only warn, maybe the next extract will work.
+			klog.Warningf("Unable to extract load balancer health monitors: %v", err)
+			return nil, err
+		}
+		return monitorList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]monitors.Monitor), nil
+}
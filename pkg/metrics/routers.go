@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	neutronRouterStatus        *prometheus.GaugeVec
+	neutronRouterAdminStateUp  *prometheus.GaugeVec
+	neutronRouterInfo          *prometheus.GaugeVec
+	neutronRouterExternalIP    *prometheus.GaugeVec
+	neutronRouterInterfaces    *prometheus.GaugeVec
+	neutronRouterL3Agent       *prometheus.GaugeVec
+	neutronRouterActiveAgents  *prometheus.GaugeVec
+	neutronRouterHostingAgents *prometheus.GaugeVec
+
+	// Status from https://github.com/openstack/neutron-lib/blob/master/neutron_lib/constants.py
+	routerStates = []string{"ACTIVE", "ALLOCATING", "BUILD", "DOWN", "ERROR"}
+
+	routerLabels = []string{"id", "name"}
+)
+
+// routerWithExt is a router including the ha attribute, which is only
+// visible for admins.
+type routerWithExt struct {
+	routers.Router
+	HA bool `json:"ha"`
+}
+
+func registerRouterMetrics() {
+	neutronRouterStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_status"),
+			Help: "Neutron router status",
+		},
+		append(routerLabels, "status"),
+	)
+	neutronRouterAdminStateUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_admin_state_up"),
+			Help: "Neutron router admin state up",
+		},
+		routerLabels,
+	)
+	neutronRouterInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_info"),
+			Help: "Neutron router information",
+		},
+		append(routerLabels, "external_network_id", "distributed", "ha"),
+	)
+	neutronRouterExternalIP = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_external_ip"),
+			Help: "Neutron router external gateway ip",
+		},
+		append(routerLabels, "external_network_id", "subnet_id", "ip_address"),
+	)
+	neutronRouterInterfaces = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_interfaces"),
+			Help: "Number of interfaces attached to a neutron router",
+		},
+		routerLabels,
+	)
+	neutronRouterL3Agent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_l3_agent_alive"),
+			Help: "L3 agent hosting a neutron router is alive",
+		},
+		append(routerLabels, "agent_id", "host", "ha_state"),
+	)
+	neutronRouterActiveAgents = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_l3_agents_active"),
+			Help: "Number of L3 agents with the active ha state for a neutron ha router",
+		},
+		routerLabels,
+	)
+	neutronRouterHostingAgents = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_router_l3_agents"),
+			Help: "Number of L3 agents hosting a neutron router",
+		},
+		routerLabels,
+	)
+
+	prometheus.MustRegister(neutronRouterStatus)
+	prometheus.MustRegister(neutronRouterAdminStateUp)
+	prometheus.MustRegister(neutronRouterInfo)
+	prometheus.MustRegister(neutronRouterExternalIP)
+	prometheus.MustRegister(neutronRouterInterfaces)
+	prometheus.MustRegister(neutronRouterL3Agent)
+	prometheus.MustRegister(neutronRouterActiveAgents)
+	prometheus.MustRegister(neutronRouterHostingAgents)
+}
+
+// PublishRouterMetrics makes the list request to the neutron api and passes
+// the result to a publish function. The L3 agents hosting a router are only
+// visible for admins, for other users they are skipped.
+func PublishRouterMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, tenantID string) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("router", "list")
+	pages, err := routers.List(client, routers.ListOpts{ProjectID: tenantID}).AllPages()
+	if mc.Observe(err) != nil {
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list routers: %v", err)
+		return err
+	}
+	var r struct {
+		Routers []routerWithExt `json:"routers"`
+	}
+	if err := (pages.(routers.RouterPage)).ExtractInto(&r); err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract routers: %v", err)
+		return err
+	}
+
+	// get the router interfaces
+	portList, err := resources.Ports()
+	if err != nil {
+		return err
+	}
+	interfaces := map[string]int{}
+	for _, port := range portList {
+		if isRouterInterface(port) {
+			interfaces[port.DeviceID]++
+		}
+	}
+
+	// second step: reset the old metrics
+	neutronRouterStatus.Reset()
+	neutronRouterAdminStateUp.Reset()
+	neutronRouterInfo.Reset()
+	neutronRouterExternalIP.Reset()
+	neutronRouterInterfaces.Reset()
+	neutronRouterL3Agent.Reset()
+	neutronRouterActiveAgents.Reset()
+	neutronRouterHostingAgents.Reset()
+
+	// third step: publish the metrics
+	listAgents := true
+	for _, router := range r.Routers {
+		publishRouterMetric(router, interfaces[router.ID])
+
+		if !listAgents {
+			continue
+		}
+		mc = newOpenStackMetric("router_l3_agents", "list")
+		pages, err := routers.ListL3Agents(client, router.ID).AllPages()
+		if mc.Observe(err) != nil {
+			if _, ok := err.(gophercloud.ErrDefault403); ok {
+				klog.V(4).Info("skipping l3 agent metrics as the api is forbidden")
+				listAgents = false
+				continue
+			}
+			// only warn, the router metrics are still valid.
+			klog.Warningf("Unable to list l3 agents of router %s: %v", router.ID, err)
+			continue
+		}
+		agents, err := routers.ExtractL3Agents(pages)
+		if err != nil {
+			klog.Warningf("Unable to extract l3 agents of router %s: %v", router.ID, err)
+			continue
+		}
+		publishRouterAgentMetrics(router, agents)
+	}
+
+	return nil
+}
+
+// publishRouterMetric extracts data from a router and exposes the metrics via prometheus
+func publishRouterMetric(router routerWithExt, interfaces int) {
+	labels := []string{router.ID, router.Name}
+
+	neutronRouterAdminStateUp.WithLabelValues(labels...).Set(boolFloat64(router.AdminStateUp))
+	neutronRouterInterfaces.WithLabelValues(labels...).Set(float64(interfaces))
+
+	infoLabels := append(labels, router.GatewayInfo.NetworkID, strconv.FormatBool(router.Distributed), strconv.FormatBool(router.HA))
+	neutronRouterInfo.WithLabelValues(infoLabels...).Set(1)
+
+	for _, ip := range router.GatewayInfo.ExternalFixedIPs {
+		ipLabels := append(labels, router.GatewayInfo.NetworkID, ip.SubnetID, ip.IPAddress)
+		neutronRouterExternalIP.WithLabelValues(ipLabels...).Set(1)
+	}
+
+	for _, status := range routerStates {
+		statusLabels := append(labels, status)
+		neutronRouterStatus.WithLabelValues(statusLabels...).Set(boolFloat64(router.Status == status))
+	}
+}
+
+// publishRouterAgentMetrics exposes the L3 agents hosting a router and, for ha
+// routers, the number of agents with the active ha state.
+func publishRouterAgentMetrics(router routerWithExt, agents []routers.L3Agent) {
+	labels := []string{router.ID, router.Name}
+
+	var active int
+	for _, agent := range agents {
+		agentLabels := append(labels, agent.ID, agent.Host, agent.HAState)
+		neutronRouterL3Agent.WithLabelValues(agentLabels...).Set(boolFloat64(agent.Alive))
+		if agent.HAState == "active" {
+			active++
+		}
+	}
+
+	neutronRouterHostingAgents.WithLabelValues(labels...).Set(float64(len(agents)))
+	if router.HA {
+		neutronRouterActiveAgents.WithLabelValues(labels...).Set(float64(active))
+	}
+}
+
+// isRouterInterface returns true if the port is an internal interface of a router
+func isRouterInterface(port ports.Port) bool {
+	return strings.HasPrefix(port.DeviceOwner, "network:router_interface") ||
+		port.DeviceOwner == "network:ha_router_replicated_interface"
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/rules"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	neutronSecurityGroupInfo        *prometheus.GaugeVec
+	neutronSecurityGroupRules       *prometheus.GaugeVec
+	neutronSecurityGroupPorts       *prometheus.GaugeVec
+	neutronSecurityGroupOpenToWorld *prometheus.GaugeVec
+
+	// ports which should not be reachable from the whole internet
+	sensitivePorts = []int{22, 2379, 2380, 3306, 3389, 5432, 6379, 6443, 9200, 10250, 10255, 27017}
+
+	// remote ip prefixes which match every address
+	worldIPPrefixes = []string{"", "0.0.0.0/0", "::/0"}
+
+	// protocols which use ports, an empty protocol allows all protocols
+	portProtocols = []string{"", "any", "tcp", "udp", "6", "17"}
+
+	securityGroupLabels = []string{"id", "name"}
+)
+
+// securityGroupWithExt is a security group including the stateful attribute
+// of the stateful-security-group extension.
+type securityGroupWithExt struct {
+	groups.SecGroup
+	securityGroupStatefulExt
+}
+
+type securityGroupStatefulExt struct {
+	// Stateful is nil if the extension is not available, security groups are stateful then.
+	Stateful *bool `json:"stateful"`
+}
+
+func registerSecurityGroupMetrics() {
+	neutronSecurityGroupInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_security_group_info"),
+			Help: "Neutron security group information",
+		},
+		append(securityGroupLabels, "stateful"),
+	)
+	neutronSecurityGroupRules = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_security_group_rules"),
+			Help: "Number of rules of a neutron security group",
+		},
+		securityGroupLabels,
+	)
+	neutronSecurityGroupPorts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_security_group_ports"),
+			Help: "Number of ports using a neutron security group",
+		},
+		securityGroupLabels,
+	)
+	neutronSecurityGroupOpenToWorld = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("neutron_security_group_rule_sensitive_ports_open"),
+			Help: "Number of sensitive ports a security group rule opens to every address",
+		},
+		append(securityGroupLabels, "rule_id", "ethertype", "protocol", "port_range_min", "port_range_max", "remote_ip_prefix"),
+	)
+
+	prometheus.MustRegister(neutronSecurityGroupInfo)
+	prometheus.MustRegister(neutronSecurityGroupRules)
+	prometheus.MustRegister(neutronSecurityGroupPorts)
+	prometheus.MustRegister(neutronSecurityGroupOpenToWorld)
+}
+
+// PublishSecurityGroupMetrics makes the list request to the neutron api and
+// passes the result to a publish function.
+func PublishSecurityGroupMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources, tenantID string) error {
+	// first step: gather the data
+	mc := newOpenStackMetric("security_group", "list")
+	pages, err := groups.List(client, groups.ListOpts{ProjectID: tenantID}).AllPages()
+	if mc.Observe(err) != nil {
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list security groups: %v", err)
+		return err
+	}
+	var securityGroupList []securityGroupWithExt
+	if err := (pages.(groups.SecGroupPage)).ExtractIntoSlicePtr(&securityGroupList, "security_groups"); err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract security groups: %v", err)
+		return err
+	}
+
+	// get the ports to count the usage of the security groups
+	portList, err := resources.Ports()
+	if err != nil {
+		return err
+	}
+	portsBySecurityGroup := map[string]int{}
+	for _, port := range portList {
+		for _, id := range port.SecurityGroups {
+			portsBySecurityGroup[id]++
+		}
+	}
+
+	// second step: reset the old metrics
+	neutronSecurityGroupInfo.Reset()
+	neutronSecurityGroupRules.Reset()
+	neutronSecurityGroupPorts.Reset()
+	neutronSecurityGroupOpenToWorld.Reset()
+
+	// third step: publish the metrics
+	for _, sg := range securityGroupList {
+		publishSecurityGroupMetric(sg, portsBySecurityGroup[sg.ID])
+	}
+
+	return nil
+}
+
+// publishSecurityGroupMetric extracts data from a security group and exposes the metrics via prometheus
+func publishSecurityGroupMetric(sg securityGroupWithExt, portCount int) {
+	labels := []string{sg.ID, sg.Name}
+
+	stateful := sg.Stateful == nil || *sg.Stateful
+	neutronSecurityGroupInfo.WithLabelValues(append(labels, strconv.FormatBool(stateful))...).Set(1)
+	neutronSecurityGroupRules.WithLabelValues(labels...).Set(float64(len(sg.Rules)))
+	neutronSecurityGroupPorts.WithLabelValues(labels...).Set(float64(portCount))
+
+	for _, rule := range sg.Rules {
+		if open := sensitivePortsOpenToWorld(rule); open > 0 {
+			ruleLabels := append(labels, rule.ID, rule.EtherType, rule.Protocol,
+				strconv.Itoa(rule.PortRangeMin), strconv.Itoa(rule.PortRangeMax), rule.RemoteIPPrefix)
+			neutronSecurityGroupOpenToWorld.WithLabelValues(ruleLabels...).Set(float64(open))
+		}
+	}
+}
+
+// sensitivePortsOpenToWorld returns the number of sensitive ports an ingress
+// rule opens to every address.
+func sensitivePortsOpenToWorld(rule rules.SecGroupRule) int {
+	if rule.Direction != "ingress" || rule.RemoteGroupID != "" || !contains(worldIPPrefixes, rule.RemoteIPPrefix) {
+		return 0
+	}
+	if !contains(portProtocols, rule.Protocol) {
+		return 0
+	}
+
+	var open int
+	for _, port := range sensitivePorts {
+		// a rule without port range allows all ports
+		if rule.PortRangeMin == 0 && rule.PortRangeMax == 0 ||
+			rule.PortRangeMin <= port && port <= rule.PortRangeMax {
+			open++
+		}
+	}
+	return open
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	serverGroupInfo                  *prometheus.GaugeVec
+	serverGroupMemberCount           *prometheus.GaugeVec
+	serverGroupMember                *prometheus.GaugeVec
+	serverGroupAntiAffinityViolation *prometheus.GaugeVec
+
+	serverGroupLabels = []string{"id", "name", "policy"}
+)
+
+func registerServerGroupMetrics() {
+	serverGroupInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_group_info"),
+			Help: "Server group information",
+		},
+		serverGroupLabels,
+	)
+	serverGroupMemberCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_group_member_count"),
+			Help: "Server group member count",
+		},
+		serverGroupLabels,
+	)
+	serverGroupMember = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_group_member"),
+			Help: "Server group member, the id label is the id of the server",
+		},
+		[]string{"server_group_id", "server_group_name", "policy", "id"},
+	)
+	serverGroupAntiAffinityViolation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("server_group_anti_affinity_violation"),
+			Help: "Number of members of an anti-affinity server group sharing the host with another member",
+		},
+		append(serverGroupLabels, "host_id"),
+	)
+
+	prometheus.MustRegister(serverGroupInfo)
+	prometheus.MustRegister(serverGroupMemberCount)
+	prometheus.MustRegister(serverGroupMember)
+	prometheus.MustRegister(serverGroupAntiAffinityViolation)
+}
+
+// PublishServerGroupMetrics makes the list request to the server group api and
+// passes the result to a publish function. The servers are used to look up
+// the host of the members.
+func PublishServerGroupMetrics(client *gophercloud.ServiceClient, resources *OpenStackResources) error {
+	// first step: gather the data
+	serversList, err := resources.Servers()
+	if err != nil {
+		return err
+	}
+
+	mc := newOpenStackMetric("server_group", "list")
+	pages, err := servergroups.List(client, servergroups.ListOpts{}).AllPages()
+	if mc.Observe(err) != nil {
+		// only warn, maybe the next list will work.
+		klog.Warningf("Unable to list server groups: %v", err)
+		return err
+	}
+	serverGroupList, err := servergroups.ExtractServerGroups(pages)
+	if err != nil {
+		// only warn, maybe the next extract will work.
+		klog.Warningf("Unable to extract server groups: %v", err)
+		return err
+	}
+
+	hostIDs := map[string]string{}
+	for _, srv := range serversList {
+		hostIDs[srv.ID] = srv.HostID
+	}
+
+	// second step: reset the old metrics
+	serverGroupInfo.Reset()
+	serverGroupMemberCount.Reset()
+	serverGroupMember.Reset()
+	serverGroupAntiAffinityViolation.Reset()
+
+	// third step: publish the metrics
+	for _, sg := range serverGroupList {
+		publishServerGroupMetric(sg, hostIDs)
+	}
+
+	return nil
+}
+
+// publishServerGroupMetric extracts data from a server group and exposes the metrics via prometheus
+func publishServerGroupMetric(sg servergroups.ServerGroup, hostIDs map[string]string) {
+	policy := serverGroupPolicy(sg)
+	labels := []string{sg.ID, sg.Name, policy}
+
+	serverGroupInfo.WithLabelValues(labels...).Set(1)
+	serverGroupMemberCount.WithLabelValues(labels...).Set(float64(len(sg.Members)))
+
+	membersByHost := map[string]int{}
+	for _, member := range sg.Members {
+		serverGroupMember.WithLabelValues(sg.ID, sg.Name, policy, member).Set(1)
+
+		// the host id is only visible if the server is part of the listed servers
+		if hostID := hostIDs[member]; hostID != "" {
+			membersByHost[hostID]++
+		}
+	}
+
+	// soft-anti-affinity allows the members to share a host
+	if policy != "anti-affinity" {
+		return
+	}
+	for hostID, count := range membersByHost {
+		if count > 1 {
+			serverGroupAntiAffinityViolation.WithLabelValues(append(labels, hostID)...).Set(float64(count))
+		}
+	}
+}
+
+// serverGroupPolicy returns the policy of a server group. Starting with
+// microversion 2.64 the policies list was replaced by a single policy.
+func serverGroupPolicy(sg servergroups.ServerGroup) string {
+	if sg.Policy != nil {
+		return *sg.Policy
+	}
+	if len(sg.Policies) > 0 {
+		return sg.Policies[0]
+	}
+	return ""
+}
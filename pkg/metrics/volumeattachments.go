@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v2/volumes"
+	"github.com/prometheus/client_golang/prometheus"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+var (
+	volumeAttachmentMismatch *prometheus.GaugeVec
+)
+
+func registerVolumeAttachmentMetrics() {
+	volumeAttachmentMismatch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: generateName("volume_attachment_mismatch"),
+			Help: "Attachment of a cinder volume on which kubernetes, cinder and nova disagree",
+		},
+		[]string{"volume_id", "pv_name", "volume_attachment", "node", "k8s_cluster", "server_id", "type"},
+	)
+
+	prometheus.MustRegister(volumeAttachmentMismatch)
+}
+
+// PublishVolumeAttachmentMetrics compares the kubernetes volume attachments
+// with the attachments of the cinder volumes and the volumes attached to the
+// nova servers and exposes every disagreement.
+func PublishVolumeAttachmentMetrics(resources *OpenStackResources, clusters []*KubernetesCache) error {
+	// first step: gather the data
+
+	// get the kubernetes objects
+	pvs, err := getPVsByCinderID(clusters)
+	if err != nil {
+		return err
+	}
+	nodes, err := getNodesByServerID(clusters)
+	if err != nil {
+		return err
+	}
+	vas := map[string][]storagev1.VolumeAttachment{}
+	for _, k8s := range clusters {
+		vas[k8s.cluster], err = getCinderVolumeAttachments(k8s)
+		if err != nil {
+			return err
+		}
+	}
+
+	volumesList, err := resources.Volumes()
+	if err != nil {
+		return err
+	}
+	volumesList = filterVolumes(volumesList)
+	// the volumes nova has attached to a server are read from the listed servers
+	serversList, err := resources.Servers()
+	if err != nil {
+		return err
+	}
+
+	// the names of pvs and nodes are only unique within a cluster
+	volumeIDsByPVName := map[clusterObjectName]string{}
+	for id, pv := range pvs {
+		volumeIDsByPVName[clusterObjectName{pv.cluster, pv.GetName()}] = id
+	}
+	serverIDsByNodeName := map[clusterObjectName]string{}
+	for id, node := range nodes {
+		serverIDsByNodeName[clusterObjectName{node.cluster, node.GetName()}] = id
+	}
+	volumesByID := map[string]volumes.Volume{}
+	for _, v := range volumesList {
+		volumesByID[v.ID] = v
+	}
+	// the volumes nova has attached to a server
+	serverVolumes := map[string]map[string]bool{}
+	for _, srv := range serversList {
+		serverVolumes[srv.ID] = map[string]bool{}
+		for _, attached := range srv.AttachedVolumes {
+			serverVolumes[srv.ID][attached.ID] = true
+		}
+	}
+
+	// second step: reset the old metrics
+	volumeAttachmentMismatch.Reset()
+
+	// third step: publish the metrics
+	for _, v := range volumesList {
+		for _, a := range v.Attachments {
+			if _, ok := serverVolumes[a.ServerID]; !ok {
+				volumeAttachmentMismatch.WithLabelValues(v.ID, pvs[v.ID].Name, "", "", pvs[v.ID].cluster, a.ServerID, "attached_to_deleted_server").Set(1)
+			}
+		}
+	}
+
+	for cluster, clusterVAs := range vas {
+		for _, va := range clusterVAs {
+			if !va.Status.Attached || va.Spec.Source.PersistentVolumeName == nil {
+				continue
+			}
+			volumeID, ok := volumeIDsByPVName[clusterObjectName{cluster, *va.Spec.Source.PersistentVolumeName}]
+			if !ok {
+				continue
+			}
+			// a volume which does not exist anymore is exposed by the pv_missing_backend metric,
+			// the volumes of other clusters are not exported
+			v, ok := volumesByID[volumeID]
+			if !ok {
+				continue
+			}
+			publishVolumeAttachmentMismatch(cluster, va, v, serverIDsByNodeName[clusterObjectName{cluster, va.Spec.NodeName}], serverVolumes)
+		}
+	}
+
+	return nil
+}
+
+// publishVolumeAttachmentMismatch compares a kubernetes volume attachment with
+// the attachments of the cinder volume and the volumes nova has attached to
+// the server of the node.
+func publishVolumeAttachmentMismatch(cluster string, va storagev1.VolumeAttachment, v volumes.Volume, serverID string, serverVolumes map[string]map[string]bool) {
+	labels := []string{v.ID, *va.Spec.Source.PersistentVolumeName, va.GetName(), va.Spec.NodeName, cluster}
+
+	if len(v.Attachments) == 0 {
+		volumeAttachmentMismatch.WithLabelValues(append(labels, serverID, "cinder_not_attached")...).Set(1)
+		return
+	}
+
+	// the server of the node is unknown, if the node is not an openstack server
+	if serverID == "" {
+		return
+	}
+
+	// multi attach volumes can be attached to other servers, too
+	for _, a := range v.Attachments {
+		if a.ServerID != serverID {
+			continue
+		}
+		if !serverVolumes[serverID][v.ID] {
+			volumeAttachmentMismatch.WithLabelValues(append(labels, serverID, "nova_not_attached")...).Set(1)
+		}
+		return
+	}
+
+	for _, a := range v.Attachments {
+		// attachments to deleted servers are already exposed
+		if _, ok := serverVolumes[a.ServerID]; ok {
+			volumeAttachmentMismatch.WithLabelValues(append(labels, a.ServerID, "attached_to_other_server")...).Set(1)
+		}
+	}
+}